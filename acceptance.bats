@@ -29,3 +29,95 @@
   [ "$status" -eq 0 ]
   git diff --quiet -- test/output/custom
 }
+
+@test "[CREATE] Checking constraints and templates with --check succeeds when up to date" {
+  run ./build/konstraint create examples --check
+  [ "$status" -eq 0 ]
+}
+
+@test "[CREATE] Checking constraints and templates with --check fails when out of date" {
+  run ./build/konstraint create test/policies --check
+  [ "$status" -eq 1 ]
+  [[ "$output" =~ "--- /dev/null" ]]
+}
+
+@test "[DOC] Checking documentation with --check succeeds when up to date" {
+  run ./build/konstraint doc examples --output examples/policies.md --check
+  [ "$status" -eq 0 ]
+}
+
+@test "[VERIFY] Verifying the examples against their fixtures succeeds" {
+  run ./build/konstraint verify examples
+  [ "$status" -eq 0 ]
+  [[ "$output" =~ "PASS ContainerDenyPrivileged" ]]
+}
+
+@test "[LINT] Linting the examples succeeds" {
+  run ./build/konstraint lint examples
+  [ "$status" -eq 0 ]
+}
+
+@test "[LINT] Linting policies with errors fails" {
+  run ./build/konstraint lint test/policies
+  [ "$status" -eq 1 ]
+  [[ "$output" =~ "(duplicate-policy-id)" ]]
+}
+
+@test "[DOC] Reporting diagnostics with --diagnostics-format writes GitHub annotations" {
+  run ./build/konstraint doc test/policies --output /dev/null --check --diagnostics-format github
+  [[ "$output" =~ "::warning file=test/policies/no-metadata/src.rego,line=1,col=1,title=missing-title::" ]]
+}
+
+@test "[CREATE] Reporting diagnostics with --diagnostics-format json succeeds without problems" {
+  run ./build/konstraint create examples --check --diagnostics-format json
+  [ "$status" -eq 0 ]
+  [ "$output" = "[]" ]
+}
+
+@test "[CREATE] Creating constraints with --keep-going generates the valid policies and fails" {
+  tmp=$(mktemp -d)
+  cp -r examples/lib examples/container_deny_privileged "$tmp/"
+  mkdir "$tmp/broken"
+  printf 'package broken\n\nviolation[msg] {\n' > "$tmp/broken/src.rego"
+  run ./build/konstraint create "$tmp" --output "$tmp/out" --keep-going
+  [ "$status" -eq 1 ]
+  [[ "$output" =~ "(rego-parse)" ]]
+  [ -f "$tmp/out/template_ContainerDenyPrivileged.yaml" ]
+}
+
+@test "[SCHEMA] The published schema matches the schema of the custom metadata" {
+  run ./build/konstraint schema
+  [ "$status" -eq 0 ]
+  diff <(./build/konstraint schema) docs/custom.schema.json
+}
+
+@test "[TEST] Running the unit tests of the examples succeeds" {
+  tmp=$(mktemp -d)
+  run ./build/konstraint test examples --data examples/test-data --junit-output "$tmp/report.xml"
+  [ "$status" -eq 0 ]
+  [[ "$output" =~ "PASS ContainerDenyPrivileged" ]]
+  grep -q '<testsuite name="lib.core"' "$tmp/report.xml"
+}
+
+@test "[TEST] Running the unit tests without their data fails" {
+  run ./build/konstraint test examples
+  [ "$status" -eq 1 ]
+  [[ "$output" =~ "FAIL RoleDenyUsePrivilegedPsps" ]]
+}
+
+@test "[EVAL] Evaluating a policy against a manifest prints the violations" {
+  run ./build/konstraint eval examples/container_deny_privileged -f examples/container_deny_privileged/test_disallowed.yaml
+  [ "$status" -eq 1 ]
+  [[ "$output" =~ "Containers must not run as privileged" ]]
+}
+
+@test "[AUDIT] Auditing manifests with violations fails" {
+  run ./build/konstraint audit examples -f examples/container_deny_privileged/test_disallowed.yaml
+  [ "$status" -eq 1 ]
+  [[ "$output" =~ "ContainerDenyPrivileged" ]]
+}
+
+@test "[AUDIT] Auditing manifests writes a JUnit report" {
+  run ./build/konstraint audit examples -f examples/container_deny_privileged/test_allowed.yaml --format junit
+  [[ "$output" =~ "<testsuites" ]]
+}
@@ -17,6 +17,11 @@
 #       - DaemonSet
 #       - Deployment
 #       - StatefulSet
+#   tests:
+#     allowed:
+#     - test_allowed.yaml
+#     disallowed:
+#     - test_disallowed.yaml
 package container_deny_privileged
 
 import data.lib.core
@@ -16,6 +16,11 @@
 #       - DaemonSet
 #       - Deployment
 #       - StatefulSet
+#   tests:
+#     allowed:
+#     - test_allowed.yaml
+#     disallowed:
+#     - test.yaml
 package container_deny_without_resource_constraints
 
 import data.lib.core
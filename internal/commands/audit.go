@@ -0,0 +1,549 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/plexsystems/konstraint/internal/gatekeeper"
+	"github.com/plexsystems/konstraint/internal/rego"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/reviews"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// auditFormats are the formats of the report of the audit command.
+var auditFormats = []string{"table", "json", "junit"}
+
+func newAuditCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "audit <dir>",
+		Short: "Audit manifests against all policies",
+		Long: `Audit evaluates every policy with a violation rule against the resources in a set
+of manifests, such as the rendered output of Helm or Kustomize, the same way the audit of
+Gatekeeper evaluates the resources in a cluster.
+
+The ConstraintTemplate and Constraints of every policy are rendered and evaluated with the
+constraint framework of Gatekeeper, so that the matchers of the policies apply. Only the
+enforcement actions at the audit.gatekeeper.sh enforcement point are reported, and policies
+that are not enforced there are skipped. The command fails when a resource violates a policy
+with the deny enforcement action.
+
+Policies that read data.inventory, such as the ones that check that the hosts of Ingresses
+are unique, are given the resources of the manifests set with --inventory, in the same layout
+as the resources that Gatekeeper syncs from the cluster.`,
+		Example: `Audit the rendered manifests of a Helm chart
+	helm template my-chart > manifests.yaml
+	konstraint audit examples -f manifests.yaml
+
+Audit a directory of manifests and write a JUnit report
+	konstraint audit examples -f manifests/ --format junit > audit.xml
+
+Audit manifests against a snapshot of the resources in a cluster
+	konstraint audit examples -f manifests.yaml --inventory snapshot/`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := viper.BindPFlag("file", cmd.Flags().Lookup("file")); err != nil {
+				return fmt.Errorf("bind file flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("format", cmd.Flags().Lookup("format")); err != nil {
+				return fmt.Errorf("bind format flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("inventory", cmd.Flags().Lookup("inventory")); err != nil {
+				return fmt.Errorf("bind inventory flag: %w", err)
+			}
+
+			files := viper.GetStringSlice("file")
+			if len(files) == 0 {
+				return fmt.Errorf("no manifests set, use --file")
+			}
+
+			format := viper.GetString("format")
+			if !contains(auditFormats, format) {
+				return fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(auditFormats, ", "))
+			}
+
+			path := "."
+			if len(args) > 0 {
+				path = args[0]
+			}
+
+			// A violation is not a usage error.
+			cmd.SilenceUsage = true
+
+			options := auditOptions{
+				files:     files,
+				inventory: viper.GetStringSlice("inventory"),
+				format:    format,
+			}
+
+			return runAuditCommand(path, options, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringSliceP("file", "f", nil, "Manifests, or directories with manifests, with the resources to audit")
+	cmd.Flags().StringSlice("inventory", nil, "Manifests, or directories with manifests, with the resources to sync into data.inventory")
+	cmd.Flags().String("format", "table", fmt.Sprintf("Format of the report (%s)", strings.Join(auditFormats, ", ")))
+
+	return &cmd
+}
+
+// auditPolicy is a Constraint of a policy, along with the resources it
+// matches and the violations of those resources.
+type auditPolicy struct {
+	Kind               string           `json:"kind"`
+	Constraint         string           `json:"constraint"`
+	Path               string           `json:"path"`
+	EnforcementActions []string         `json:"enforcementActions"`
+	Matched            int              `json:"matched"`
+	Violations         []auditViolation `json:"violations"`
+
+	policy  rego.Rego
+	matched []*auditResource
+}
+
+// auditSkippedPolicy is a policy that could not be audited, along with the
+// reason why.
+type auditSkippedPolicy struct {
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// auditResource is a resource of the manifests, along with its violations.
+type auditResource struct {
+	File       string           `json:"file"`
+	APIVersion string           `json:"apiVersion"`
+	Kind       string           `json:"kind"`
+	Namespace  string           `json:"namespace,omitempty"`
+	Name       string           `json:"name"`
+	Error      string           `json:"error,omitempty"`
+	Violations []auditViolation `json:"violations"`
+
+	manifest fixture
+}
+
+func (r *auditResource) String() string {
+	name := r.Name
+	if r.Namespace != "" {
+		name = r.Namespace + "/" + name
+	}
+
+	return r.Kind + " " + name
+}
+
+// auditViolation is a violation of a policy by a resource.
+type auditViolation struct {
+	Policy             string   `json:"policy"`
+	Constraint         string   `json:"constraint"`
+	EnforcementActions []string `json:"enforcementActions"`
+	Resource           string   `json:"resource"`
+	File               string   `json:"file"`
+	Message            string   `json:"message"`
+}
+
+func (v auditViolation) denied() bool {
+	return contains(v.EnforcementActions, "deny")
+}
+
+type auditOptions struct {
+	files     []string
+	inventory []string
+	format    string
+}
+
+func runAuditCommand(path string, options auditOptions, out io.Writer) error {
+	ctx := context.Background()
+
+	violations, err := rego.GetViolations(path)
+	if err != nil {
+		return fmt.Errorf("get violations: %w", err)
+	}
+
+	client, err := gatekeeper.NewClient()
+	if err != nil {
+		return fmt.Errorf("new client: %w", err)
+	}
+
+	var policies []*auditPolicy
+	var skipped []auditSkippedPolicy
+	policiesByConstraint := make(map[string]*auditPolicy)
+	for _, violation := range violations {
+		logger := log.WithFields(log.Fields{
+			"name": violation.Kind(),
+			"src":  violation.Path(),
+		})
+
+		if violation.SkipConstraint() {
+			logger.Debug("Skipping policy without a Constraint")
+			continue
+		}
+
+		// The policy is listed in the report, so that it is not mistaken for
+		// a policy without violations.
+		if unset := getParametersWithoutDefault(violation); len(unset) > 0 {
+			logger.Warn("Skipping policy due to use of parameters without a default and without custom.constraints")
+			skipped = append(skipped, auditSkippedPolicy{
+				Kind:   violation.Kind(),
+				Path:   violation.Path(),
+				Reason: "parameters without a default and without custom.constraints: " + strings.Join(unset, ", "),
+			})
+			continue
+		}
+
+		instances, err := addPolicy(ctx, client, violation, logger)
+		if err != nil {
+			return fmt.Errorf("add policy %s: %w", violation.Path(), err)
+		}
+
+		for _, instance := range instances {
+			actions := instance.EnforcementActionsAt(rego.EnforcementPointAudit)
+			if len(actions) == 0 {
+				logger.WithField("constraint", instance.Name()).Debug("Skipping Constraint that is not enforced by audit")
+				continue
+			}
+
+			policy := &auditPolicy{
+				Kind:               instance.Kind(),
+				Constraint:         instance.Name(),
+				Path:               instance.Path(),
+				EnforcementActions: actions,
+				policy:             instance,
+			}
+			policies = append(policies, policy)
+			policiesByConstraint[policy.Kind+"/"+policy.Constraint] = policy
+		}
+	}
+
+	manifests, err := readManifests(options.files)
+	if err != nil {
+		return fmt.Errorf("read manifests: %w", err)
+	}
+
+	var resources []*auditResource
+	for _, manifest := range manifests {
+		resources = append(resources, &auditResource{
+			File:       manifest.path,
+			APIVersion: manifest.object.GetAPIVersion(),
+			Kind:       manifest.object.GetKind(),
+			Namespace:  manifest.object.GetNamespace(),
+			Name:       manifest.object.GetName(),
+			manifest:   manifest,
+		})
+	}
+
+	inventory, err := readManifests(options.inventory)
+	if err != nil {
+		return fmt.Errorf("read inventory: %w", err)
+	}
+	for _, resource := range inventory {
+		if _, err := client.AddData(ctx, resource.object); err != nil {
+			return fmt.Errorf("add %s to the inventory: %w", resource, err)
+		}
+	}
+
+	// Namespaces in the manifests and the inventory are used to evaluate
+	// namespaceSelectors.
+	namespaces := getNamespaces(append(inventory, manifests...))
+
+	for _, resource := range resources {
+		object := resource.manifest.review(namespaces)
+
+		for _, policy := range policies {
+			matched, err := gatekeeper.Matches(policy.policy.AnnotationMatchers(), object)
+			if err != nil {
+				resource.Error = fmt.Sprintf("match %s: %s", policy.Constraint, err)
+				break
+			}
+			if matched {
+				policy.Matched++
+				policy.matched = append(policy.matched, resource)
+			}
+		}
+		if resource.Error != "" {
+			continue
+		}
+
+		resp, err := client.Review(ctx, object, reviews.EnforcementPoint(rego.EnforcementPointAudit))
+		if err != nil {
+			resource.Error = err.Error()
+			continue
+		}
+
+		for _, result := range resp.Results() {
+			policy, ok := policiesByConstraint[result.Constraint.GetKind()+"/"+result.Constraint.GetName()]
+			if !ok {
+				continue
+			}
+
+			violation := auditViolation{
+				Policy:             policy.Kind,
+				Constraint:         policy.Constraint,
+				EnforcementActions: policy.EnforcementActions,
+				Resource:           resource.String(),
+				File:               resource.File,
+				Message:            result.Msg,
+			}
+			policy.Violations = append(policy.Violations, violation)
+			resource.Violations = append(resource.Violations, violation)
+		}
+	}
+
+	switch options.format {
+	case "json":
+		err = writeAuditJSON(out, policies, skipped, resources)
+	case "junit":
+		err = writeAuditJUnit(out, policies, skipped, resources)
+	default:
+		err = writeAuditTable(out, policies, skipped, resources)
+	}
+	if err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+
+	var denied, errored int
+	for _, resource := range resources {
+		if resource.Error != "" {
+			errored++
+		}
+		for _, violation := range resource.Violations {
+			if violation.denied() {
+				denied++
+			}
+		}
+	}
+
+	if errored > 0 {
+		return fmt.Errorf("%d of %d resources could not be audited", errored, len(resources))
+	}
+	if denied > 0 {
+		return fmt.Errorf("found %d violation(s) with the deny enforcement action", denied)
+	}
+
+	log.WithFields(log.Fields{
+		"num_policies":  len(policies),
+		"num_resources": len(resources),
+	}).Info("completed successfully")
+
+	return nil
+}
+
+// readManifests reads the resources from the files, and from the YAML and
+// JSON files in the directories.
+func readManifests(paths []string) ([]fixture, error) {
+	var files []string
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if d.IsDir() {
+				return nil
+			}
+
+			// Files that are set explicitly are always read.
+			switch filepath.Ext(file) {
+			case ".yaml", ".yml", ".json":
+				files = append(files, file)
+			default:
+				if file == path {
+					files = append(files, file)
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("walk %s: %w", path, err)
+		}
+	}
+
+	var manifests []fixture
+	for _, file := range files {
+		objects, err := gatekeeper.ReadObjects(file)
+		if err != nil {
+			return nil, fmt.Errorf("read objects: %w", err)
+		}
+
+		for _, object := range objects {
+			manifest, err := newFixture(file, object)
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, manifest)
+		}
+	}
+
+	return manifests, nil
+}
+
+// getNamespaces returns the Namespaces of the manifests by their names.
+func getNamespaces(manifests []fixture) map[string]*unstructured.Unstructured {
+	namespaces := make(map[string]*unstructured.Unstructured)
+	for _, manifest := range manifests {
+		if manifest.object.GetAPIVersion() == "v1" && manifest.object.GetKind() == "Namespace" {
+			namespaces[manifest.object.GetName()] = manifest.object
+		}
+	}
+
+	return namespaces
+}
+
+// writeAuditTable writes the violations grouped by policy, followed by the
+// resources with violations or errors, and the policies that were skipped.
+func writeAuditTable(out io.Writer, policies []*auditPolicy, skipped []auditSkippedPolicy, resources []*auditResource) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "POLICY\tCONSTRAINT\tACTIONS\tRESOURCE\tMESSAGE")
+	for _, policy := range policies {
+		violations := append([]auditViolation{}, policy.Violations...)
+		sort.SliceStable(violations, func(i, j int) bool {
+			return violations[i].Resource < violations[j].Resource
+		})
+
+		for _, violation := range violations {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", policy.Kind, policy.Constraint, strings.Join(policy.EnforcementActions, ","), violation.Resource, violation.Message)
+		}
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "RESOURCE\tFILE\tVIOLATIONS\tDENIED\tERROR")
+	for _, resource := range resources {
+		if len(resource.Violations) == 0 && resource.Error == "" {
+			continue
+		}
+
+		var denied int
+		for _, violation := range resource.Violations {
+			if violation.denied() {
+				denied++
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", resource, resource.File, len(resource.Violations), denied, resource.Error)
+	}
+
+	if len(skipped) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "SKIPPED POLICY\tPATH\tREASON")
+		for _, policy := range skipped {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", policy.Kind, policy.Path, policy.Reason)
+		}
+	}
+
+	return w.Flush()
+}
+
+func writeAuditJSON(out io.Writer, policies []*auditPolicy, skipped []auditSkippedPolicy, resources []*auditResource) error {
+	report := struct {
+		Policies  []*auditPolicy       `json:"policies"`
+		Skipped   []auditSkippedPolicy `json:"skipped,omitempty"`
+		Resources []*auditResource     `json:"resources"`
+	}{policies, skipped, resources}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return fmt.Errorf("encode report: %w", err)
+	}
+
+	return nil
+}
+
+// writeAuditJUnit writes a test suite for every Constraint, with a test case
+// for every resource that it matches. The violations with the deny
+// enforcement action are failures. A policy that was skipped has a suite with
+// a single skipped test case.
+func writeAuditJUnit(out io.Writer, policies []*auditPolicy, skipped []auditSkippedPolicy, resources []*auditResource) error {
+	var suites junitTestSuites
+	for _, policy := range policies {
+		suite := junitTestSuite{
+			Name: policy.Kind + "/" + policy.Constraint,
+			Time: junitTime(0),
+			Properties: []junitProperty{
+				{Name: "path", Value: policy.Path},
+				{Name: "enforcementActions", Value: strings.Join(policy.EnforcementActions, ",")},
+			},
+		}
+
+		for _, resource := range policy.matched {
+			testCase := junitTestCase{
+				ClassName: policy.Kind,
+				Name:      resource.String(),
+				File:      resource.File,
+				Time:      junitTime(0),
+			}
+
+			var messages []string
+			for _, violation := range resource.Violations {
+				if violation.Constraint == policy.Constraint && violation.Policy == policy.Kind {
+					messages = append(messages, violation.Message)
+				}
+			}
+			switch {
+			case resource.Error != "":
+				testCase.Error = &junitMessage{Message: resource.Error}
+				suite.Errors++
+			case len(messages) > 0 && contains(policy.EnforcementActions, "deny"):
+				testCase.Failure = &junitMessage{Message: strings.Join(messages, "\n")}
+				suite.Failures++
+			case len(messages) > 0:
+				testCase.SystemOut = strings.Join(messages, "\n")
+			}
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	for _, policy := range skipped {
+		suites.Suites = append(suites.Suites, junitTestSuite{
+			Name:       policy.Kind,
+			Skipped:    1,
+			Time:       junitTime(0),
+			Properties: []junitProperty{{Name: "path", Value: policy.Path}},
+			TestCases: []junitTestCase{{
+				ClassName: policy.Kind,
+				Name:      "audit",
+				File:      policy.Path,
+				Time:      junitTime(0),
+				Skipped:   &junitMessage{Message: policy.Reason},
+			}},
+		})
+	}
+
+	// Resources that could not be matched against the policies are reported
+	// in a suite of their own.
+	errors := junitTestSuite{Name: "errors", Time: junitTime(0)}
+	for _, resource := range resources {
+		if resource.Error == "" {
+			continue
+		}
+
+		errors.TestCases = append(errors.TestCases, junitTestCase{
+			ClassName: "audit",
+			Name:      resource.String(),
+			File:      resource.File,
+			Time:      junitTime(0),
+			Error:     &junitMessage{Message: resource.Error},
+		})
+		errors.Errors++
+	}
+	if len(errors.TestCases) > 0 {
+		suites.Suites = append(suites.Suites, errors)
+	}
+
+	return encodeJUnit(out, suites)
+}
@@ -0,0 +1,282 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRunAuditCommand(t *testing.T) {
+	files := map[string]string{
+		"policies/pod_deny_host_network/src.rego": `# METADATA
+# title: Host network
+# custom:
+#   matchers:
+#     kinds:
+#     - apiGroups: [""]
+#       kinds: ["Pod"]
+package pod_deny_host_network
+
+violation[{"msg": msg}] {
+	input.review.object.spec.hostNetwork
+	msg := "uses the host network"
+}
+`,
+		"policies/any_warn_missing_team/src.rego": `# METADATA
+# title: Team label
+# custom:
+#   enforcement: warn
+package any_warn_missing_team
+
+violation[{"msg": msg}] {
+	not input.review.object.metadata.labels.team
+	msg := "has no team label"
+}
+`,
+		"policies/any_deny_all/src.rego": `# METADATA
+# title: Deny all at admission
+# custom:
+#   scopedEnforcementActions:
+#   - action: deny
+#     enforcementPoints:
+#     - name: validation.gatekeeper.sh
+package any_deny_all
+
+violation[{"msg": msg}] {
+	msg := "denied"
+}
+`,
+		"policies/any_deny_missing_labels/src.rego": `# METADATA
+# title: Required labels
+# custom:
+#   parameters:
+#     labels:
+#       type: array
+#       items:
+#         type: string
+package any_deny_missing_labels
+
+import future.keywords.in
+
+violation[{"msg": msg}] {
+	some label in input.parameters.labels
+	not input.review.object.metadata.labels[label]
+	msg := sprintf("has no %s label", [label])
+}
+`,
+		"policies/any_warn_missing_owner/src.rego": `# METADATA
+# title: Owner label
+# custom:
+#   enforcement: warn
+#   parameters:
+#     label:
+#       type: string
+#       default: owner
+package any_warn_missing_owner
+
+violation[{"msg": msg}] {
+	not input.review.object.metadata.labels[input.parameters.label]
+	msg := sprintf("has no %s label", [input.parameters.label])
+}
+`,
+		"manifests/pod.yaml": `apiVersion: v1
+kind: Pod
+metadata:
+  name: host
+  namespace: default
+  labels:
+    team: payments
+spec:
+  hostNetwork: true
+`,
+		"manifests/service.yaml": `apiVersion: v1
+kind: Service
+metadata:
+  name: frontend
+  namespace: default
+`,
+		"manifests/README.md": `Not a manifest.`,
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	policies := filepath.Join(dir, "policies")
+	manifests := []string{filepath.Join(dir, "manifests")}
+
+	t.Run("JSON", func(t *testing.T) {
+		var out bytes.Buffer
+		err := runAuditCommand(policies, auditOptions{files: manifests, format: "json"}, &out)
+		if err == nil {
+			t.Errorf("expected error for the denied violation, got none")
+		}
+
+		var report struct {
+			Policies  []auditPolicy        `json:"policies"`
+			Skipped   []auditSkippedPolicy `json:"skipped"`
+			Resources []auditResource      `json:"resources"`
+		}
+		if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+			t.Fatalf("unmarshal report: %s", err)
+		}
+
+		violations := make(map[string][]string)
+		for _, policy := range report.Policies {
+			for _, v := range policy.Violations {
+				violations[policy.Kind] = append(violations[policy.Kind], v.Resource+": "+v.Message)
+			}
+		}
+
+		// The parameter of the owner policy is set to its default.
+		expected := map[string][]string{
+			"AnyWarnMissingOwner": {"Pod default/host: has no owner label", "Service default/frontend: has no owner label"},
+			"AnyWarnMissingTeam":  {"Service default/frontend: has no team label"},
+			"PodDenyHostNetwork":  {"Pod default/host: uses the host network"},
+		}
+		if len(report.Policies) != 3 {
+			t.Errorf("expected the policies that are not enforced by audit or have unset parameters to be left out, got %d policies", len(report.Policies))
+		}
+		for kind, want := range expected {
+			got := violations[kind]
+			sort.Strings(got)
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("unexpected violations of %s (-want +got):\n%s", kind, diff)
+			}
+		}
+
+		if len(report.Skipped) != 1 || report.Skipped[0].Kind != "AnyDenyMissingLabels" {
+			t.Errorf("expected the policy with unset parameters to be reported as skipped, got %v", report.Skipped)
+		}
+
+		if len(report.Resources) != 2 {
+			t.Errorf("expected 2 resources, got %d", len(report.Resources))
+		}
+	})
+
+	t.Run("Warnings only", func(t *testing.T) {
+		var out bytes.Buffer
+		err := runAuditCommand(policies, auditOptions{files: []string{filepath.Join(dir, "manifests", "service.yaml")}, format: "table"}, &out)
+		if err != nil {
+			t.Errorf("unexpected error for a warning: %s", err)
+		}
+		if !strings.Contains(out.String(), "SKIPPED POLICY") || !strings.Contains(out.String(), "AnyDenyMissingLabels") {
+			t.Errorf("expected the skipped policy in the report, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("JUnit", func(t *testing.T) {
+		var out bytes.Buffer
+		_ = runAuditCommand(policies, auditOptions{files: manifests, format: "junit"}, &out)
+
+		var report junitTestSuites
+		if err := xml.Unmarshal(out.Bytes(), &report); err != nil {
+			t.Fatalf("unmarshal report: %s", err)
+		}
+
+		// The Pod and the Service are both matched by the warnings, only the Pod
+		// by the denial, and the skipped policy has a test case of its own.
+		if report.Tests != 6 || report.Failures != 1 {
+			t.Errorf("expected 6 tests and 1 failure, got %d tests and %d failures", report.Tests, report.Failures)
+		}
+
+		var skipped int
+		for _, suite := range report.Suites {
+			skipped += suite.Skipped
+		}
+		if skipped != 1 {
+			t.Errorf("expected 1 skipped test, got %d", skipped)
+		}
+	})
+}
+
+func TestRunAuditCommandInventory(t *testing.T) {
+	files := map[string]string{
+		"policies/ingress_deny_duplicate_host/src.rego": `# METADATA
+# title: Unique Ingress hosts
+# custom:
+#   matchers:
+#     kinds:
+#     - apiGroups: ["networking.k8s.io"]
+#       kinds: ["Ingress"]
+package ingress_deny_duplicate_host
+
+violation[{"msg": msg}] {
+	host := input.review.object.spec.rules[_].host
+	other := data.inventory.namespace[namespace][_].Ingress[name]
+	[namespace, name] != [input.review.object.metadata.namespace, input.review.object.metadata.name]
+	other.spec.rules[_].host == host
+	msg := sprintf("host %s is also used by %s/%s", [host, namespace, name])
+}
+`,
+		"manifests/ingress.yaml": `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: frontend
+  namespace: shop
+spec:
+  rules:
+  - host: shop.example.com
+`,
+		"cluster/ingresses.yaml": `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: legacy
+  namespace: legacy
+spec:
+  rules:
+  - host: shop.example.com
+`,
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	policies := filepath.Join(dir, "policies")
+	manifests := []string{filepath.Join(dir, "manifests")}
+
+	var out bytes.Buffer
+	if err := runAuditCommand(policies, auditOptions{files: manifests, format: "table"}, &out); err != nil {
+		t.Errorf("unexpected error without an inventory: %s", err)
+	}
+
+	out.Reset()
+	options := auditOptions{files: manifests, inventory: []string{filepath.Join(dir, "cluster")}, format: "table"}
+	if err := runAuditCommand(policies, options, &out); err == nil {
+		t.Errorf("expected error for the duplicate host, got none")
+	}
+	if expected := "host shop.example.com is also used by legacy/legacy"; !bytes.Contains(out.Bytes(), []byte(expected)) {
+		t.Errorf("expected %q in the report, got:\n%s", expected, out.String())
+	}
+
+	out.Reset()
+	evalOptions := evalOptions{files: manifests, inventory: []string{filepath.Join(dir, "cluster")}}
+	if err := runEvalCommand(filepath.Join(policies, "ingress_deny_duplicate_host"), evalOptions, &out); err == nil {
+		t.Errorf("expected error for the duplicate host, got none")
+	}
+	if expected := "  host shop.example.com is also used by legacy/legacy"; !bytes.Contains(out.Bytes(), []byte(expected)) {
+		t.Errorf("expected %q in the output, got:\n%s", expected, out.String())
+	}
+}
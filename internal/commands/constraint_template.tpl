@@ -13,7 +13,10 @@ spec:
   {{- if ne .Enforcement "deny" }}
   enforcementAction: {{ .Enforcement }}
   {{- end -}}
-  {{- if or .AnnotationKindMatchers .AnnotationNamespaceMatchers .AnnotationExcludedNamespaceMatchers .AnnotationLabelSelectorMatcher }}
+  {{- if .ScopedEnforcementActions }}
+  scopedEnforcementActions: {{- .ScopedEnforcementActions | toJSON | fromJSON | toIndentYAML 2 | nindent 4 }}
+  {{- end -}}
+  {{- if or .AnnotationKindMatchers .AnnotationScopeMatcher .AnnotationNamespaceMatchers .AnnotationExcludedNamespaceMatchers .AnnotationLabelSelectorMatcher .AnnotationNamespaceSelectorMatcher .AnnotationNameMatcher .AnnotationSourceMatcher }}
   match:
   {{- if .AnnotationExcludedNamespaceMatchers }}
     excludedNamespaces: {{- .AnnotationExcludedNamespaceMatchers | toIndentYAML 2 | nindent 6 }}
@@ -24,7 +27,22 @@ spec:
   {{- if .AnnotationLabelSelectorMatcher }}
     labelSelector: {{- .AnnotationLabelSelectorMatcher | toJSON | fromJSON | toIndentYAML 2 | nindent 6 }}
   {{- end }}
+  {{- if .AnnotationNameMatcher }}
+    name: {{ .AnnotationNameMatcher | quote }}
+  {{- end }}
+  {{- if .AnnotationNamespaceSelectorMatcher }}
+    namespaceSelector: {{- .AnnotationNamespaceSelectorMatcher | toJSON | fromJSON | toIndentYAML 2 | nindent 6 }}
+  {{- end }}
   {{- if .AnnotationNamespaceMatchers }}
     namespaces: {{- .AnnotationNamespaceMatchers | toIndentYAML 2 | nindent 6 }}
   {{- end }}
+  {{- if .AnnotationScopeMatcher }}
+    scope: {{ .AnnotationScopeMatcher | quote }}
+  {{- end }}
+  {{- if .AnnotationSourceMatcher }}
+    source: {{ .AnnotationSourceMatcher }}
+  {{- end }}
+  {{- end }}
+  {{- if .ConstraintParameters }}
+  parameters: {{- .ConstraintParameters | toIndentYAML 2 | nindent 4 }}
   {{- end }}
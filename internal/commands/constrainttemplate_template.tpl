@@ -2,7 +2,7 @@
 apiVersion: templates.gatekeeper.sh/v1
 kind: ConstraintTemplate
 metadata:
-  name: {{ .Name }}
+  name: {{ .TemplateName }}
 spec:
   crd:
     spec:
@@ -17,5 +17,10 @@ spec:
   - libs: {{- range .Dependencies }}
     - |- {{- . | nindent 6 -}}
     {{ end }}
+    {{- if .AnnotationOperations }}
+    operations: {{- range .AnnotationOperations }}
+    - {{ . | quote }}
+    {{- end }}
+    {{- end }}
     rego: |- {{- .Source | nindent 6 }}
     target: admission.k8s.gatekeeper.sh
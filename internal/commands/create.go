@@ -2,19 +2,29 @@ package commands
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"text/template"
 
+	"github.com/plexsystems/konstraint/internal/diagnostic"
 	"github.com/plexsystems/konstraint/internal/rego"
 
 	"github.com/go-sprout/sprout/sprigin"
 	v1 "github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1"
 	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
+	"github.com/open-policy-agent/opa/ast"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -22,6 +32,10 @@ import (
 	"sigs.k8s.io/yaml"
 )
 
+// yamlKeyRE matches a line of YAML that starts a mapping key, capturing the
+// indentation and the (optionally quoted) key.
+var yamlKeyRE = regexp.MustCompile(`^( *)"?([^":#\-][^":#]*)"?:( |$)`)
+
 func newCreateCommand() *cobra.Command {
 	cmd := cobra.Command{
 		Use:   "create <dir>",
@@ -33,7 +47,25 @@ Save the constraints in a specific directory
 	konstraint create examples --output generated-constraints
 
 Create constraints with the Gatekeeper enforcement action set to dryrun
-	konstraint create examples --dryrun`,
+	konstraint create examples --dryrun
+
+Check that the constraints in the same directories as the policies are up to date
+	konstraint create examples --check
+
+Remove resources of deleted or renamed policies from the output directory
+	konstraint create examples --output generated-constraints --prune
+
+Report all problems with the policies as SARIF
+	konstraint create examples --diagnostics-format sarif > konstraint.sarif
+
+Create the constraints of the valid policies, and report the problems with the others
+	konstraint create examples --keep-going
+
+Also create a SyncSet with the resources that the policies read from data.inventory
+	konstraint create examples --output generated-constraints --sync-resource syncset
+
+Create ValidatingAdmissionPolicies from the CEL of the policies, for clusters without Gatekeeper
+	konstraint create examples --output generated-policies --target vap`,
 
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := viper.BindPFlag("dryrun", cmd.PersistentFlags().Lookup("dryrun")); err != nil {
@@ -59,13 +91,75 @@ Create constraints with the Gatekeeper enforcement action set to dryrun
 				return fmt.Errorf("bind partial-constraints flag: %w", err)
 			}
 
+			if err := viper.BindPFlag("check", cmd.PersistentFlags().Lookup("check")); err != nil {
+				return fmt.Errorf("bind check flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("prune", cmd.PersistentFlags().Lookup("prune")); err != nil {
+				return fmt.Errorf("bind prune flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("diagnostics-format", cmd.PersistentFlags().Lookup("diagnostics-format")); err != nil {
+				return fmt.Errorf("bind diagnostics-format flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("keep-going", cmd.PersistentFlags().Lookup("keep-going")); err != nil {
+				return fmt.Errorf("bind keep-going flag: %w", err)
+			}
+
 			if err := viper.BindPFlag("log-level", cmd.PersistentFlags().Lookup("log-level")); err != nil {
 				return fmt.Errorf("bind log-level flag: %w", err)
 			}
 
+			if err := viper.BindPFlag("sync-resource", cmd.PersistentFlags().Lookup("sync-resource")); err != nil {
+				return fmt.Errorf("bind sync-resource flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("sync-namespace", cmd.PersistentFlags().Lookup("sync-namespace")); err != nil {
+				return fmt.Errorf("bind sync-namespace flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("library-annotations", cmd.PersistentFlags().Lookup("library-annotations")); err != nil {
+				return fmt.Errorf("bind library-annotations flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("target", cmd.PersistentFlags().Lookup("target")); err != nil {
+				return fmt.Errorf("bind target flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("generate-vap", cmd.PersistentFlags().Lookup("generate-vap")); err != nil {
+				return fmt.Errorf("bind generate-vap flag: %w", err)
+			}
+
 			if cmd.PersistentFlags().Lookup("constraint-template-custom-template-file").Changed && cmd.PersistentFlags().Lookup("constraint-template-version").Changed {
 				return fmt.Errorf("need to set either constraint-template-custom-template-file or constraint-template-version")
 			}
+			if viper.GetBool("prune") && viper.GetString("output") == "" {
+				return fmt.Errorf("prune can only be used together with output")
+			}
+			if target := viper.GetString("target"); !contains(createTargets, target) {
+				return fmt.Errorf("unknown target %q, must be one of %s", target, strings.Join(createTargets, ", "))
+			}
+			if viper.GetString("target") == targetVAP && (viper.GetString("constraint-template-custom-template-file") != "" || viper.GetString("constraint-custom-template-file") != "") {
+				return fmt.Errorf("custom template files cannot be used with the %s target", targetVAP)
+			}
+			if viper.GetBool("generate-vap") {
+				if viper.GetString("target") == targetVAP {
+					return fmt.Errorf("generate-vap cannot be used with the %s target", targetVAP)
+				}
+				if viper.GetString("constraint-template-custom-template-file") == "" && viper.GetString("constraint-template-version") != "v1" {
+					return fmt.Errorf("generate-vap requires constraint-template-version v1")
+				}
+			}
+			if sync := viper.GetString("sync-resource"); sync != "" && !contains(syncResourceKinds, sync) {
+				return fmt.Errorf("unknown sync resource %q, must be one of %s", sync, strings.Join(syncResourceKinds, ", "))
+			}
+			if cmd.PersistentFlags().Lookup("sync-namespace").Changed && viper.GetString("sync-resource") != "config" {
+				return fmt.Errorf("sync-namespace can only be used together with sync-resource config")
+			}
+			if err := validateDiagnosticsFormat(viper.GetString("diagnostics-format")); err != nil {
+				return err
+			}
 			if cmd.PersistentFlags().Lookup("log-level").Changed {
 				level, err := log.ParseLevel(viper.GetString("log-level"))
 				if err != nil {
@@ -78,6 +172,10 @@ Create constraints with the Gatekeeper enforcement action set to dryrun
 				path = args[0]
 			}
 
+			// A file that is out of date or a problem with a policy is not a
+			// usage error.
+			cmd.SilenceUsage = viper.GetBool("check") || viper.GetBool("keep-going") || viper.GetString("diagnostics-format") != ""
+
 			return runCreateCommand(path)
 		},
 	}
@@ -89,84 +187,376 @@ Create constraints with the Gatekeeper enforcement action set to dryrun
 	cmd.PersistentFlags().Bool("partial-constraints", false, "Generate partial Constraints for policies with parameters")
 	cmd.PersistentFlags().String("constraint-template-custom-template-file", "", "Path to a custom template file to generate constraint templates")
 	cmd.PersistentFlags().String("constraint-custom-template-file", "", "Path to a custom template file to generate constraints")
+	cmd.PersistentFlags().Bool("prune", false, "Remove previously generated resources from the output directory that no longer match a policy")
+	cmd.PersistentFlags().Bool("check", false, "Check that the generated resources on disk are up to date without writing them")
+	cmd.PersistentFlags().String("diagnostics-format", "", "Report all errors and warnings of the policies on stdout in this format. Options: json, sarif, github")
+	cmd.PersistentFlags().Bool("keep-going", false, "Generate the resources of the valid policies even if other policies have errors")
 	cmd.PersistentFlags().String("log-level", "info", "Set a log level. Options: error, info, debug, trace")
+	cmd.PersistentFlags().String("sync-resource", "", "Also create a Gatekeeper resource that replicates the resources the policies read from data.inventory. Options: config, syncset")
+	cmd.PersistentFlags().String("sync-namespace", "gatekeeper-system", "Set the namespace of the Config created with sync-resource config, which must be the namespace Gatekeeper is installed in")
+	cmd.PersistentFlags().String("target", targetGatekeeper, "Set the kind of resources to create. Options: gatekeeper, vap")
+	cmd.PersistentFlags().Bool("generate-vap", false, "Have Gatekeeper generate ValidatingAdmissionPolicies from the CEL of the policies, unless they set generateVAP")
+	cmd.PersistentFlags().Bool("library-annotations", false, "Set the title, version and description annotations of the gatekeeper-library on the ConstraintTemplates")
 	return &cmd
 }
 
 func runCreateCommand(path string) error {
+	var diagnostics diagnostic.List
+
 	violations, err := rego.GetViolations(path)
-	if err != nil {
+	if err := addDiagnostics(&diagnostics, err); err != nil {
 		return fmt.Errorf("get violations: %w", err)
 	}
 
+	if err := addDiagnostics(&diagnostics, checkConflicts(violations, viper.GetString("output"))); err != nil {
+		return fmt.Errorf("check conflicts: %w", err)
+	}
+
+	// Policies with errors, such as a conflict with another policy, are not
+	// rendered.
+	failed := make(map[string]bool)
+	for _, d := range diagnostics {
+		if d.Severity == diagnostic.Error {
+			failed[d.File] = true
+		}
+	}
+
+	var files []generatedFile
+	var syncData [][]rego.SyncData
+	var rendered int
 	for _, violation := range violations {
 		logger := log.WithFields(log.Fields{
 			"name": violation.Kind(),
 			"src":  violation.Path(),
 		})
 
+		if failed[violation.Location().File] {
+			logger.Debug("Skipping policy with errors")
+			continue
+		}
+
 		logger.Debug("Rendering policy")
+		addUnusedParameters(&diagnostics, violation)
+		addUndeterminedSyncData(&diagnostics, violation)
 
-		if violation.SkipTemplate() {
-			logger.Info("Skipping constrainttemplate generation due to configuration")
+		policyFiles, err := renderPolicy(violation, logger, &diagnostics)
+		if err != nil {
+			diagnostics = append(diagnostics, diagnostic.FromError(diagnostic.CodeRenderFailed, violation.Location(), err)...)
 			continue
 		}
+		files = append(files, policyFiles...)
+		rendered++
 
-		if !isValidEnforcementAction(violation.Enforcement()) {
-			return fmt.Errorf("enforcement action (%v) is invalid in policy: %s", violation.Enforcement(), violation.Path())
+		if !violation.SkipTemplate() {
+			syncData = append(syncData, violation.RequiresSyncData()...)
 		}
+	}
 
-		templateFileName := "template.yaml"
-		constraintFileName := "constraint.yaml"
-		outputDir := filepath.Dir(violation.Path())
+	if kind := viper.GetString("sync-resource"); kind != "" {
+		outputDir := path
 		if viper.GetString("output") != "" {
 			outputDir = viper.GetString("output")
-			templateFileName = fmt.Sprintf("template_%s.yaml", violation.Kind())
-			constraintFileName = fmt.Sprintf("constraint_%s.yaml", violation.Kind())
 		}
 
-		if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
-			return fmt.Errorf("create output dir: %w", err)
+		file, err := renderSyncResource(kind, viper.GetString("sync-namespace"), syncData, outputDir)
+		if err != nil {
+			return fmt.Errorf("render sync resource: %w", err)
 		}
+		files = append(files, file)
+	}
+
+	// With keep-going, the resources of the valid policies are still
+	// generated, and the problems are returned after that.
+	format := viper.GetString("diagnostics-format")
+	reportErr := reportDiagnostics(diagnostics, format, os.Stdout)
+	if reportErr != nil && !viper.GetBool("keep-going") {
+		return reportErr
+	}
 
-		constraintTemplateVersion := viper.GetString("constraint-template-version")
-		constraintTemplateCustomTemplateFile := viper.GetString("constraint-template-custom-template-file")
+	if outputDir := viper.GetString("output"); outputDir != "" {
+		// The resources of the policies with errors are not generated, and
+		// must not be pruned.
+		prune := viper.GetBool("prune")
+		if prune && reportErr != nil {
+			log.Warn("Not pruning the output directory, as some policies have errors")
+			prune = false
+		}
 
-		constraintTemplate, err := renderConstraintTemplate(violation, constraintTemplateVersion, constraintTemplateCustomTemplateFile, logger)
+		manifest, err := updateManifest(outputDir, files, prune)
 		if err != nil {
-			return fmt.Errorf("rendering ConstraintTemplate: %w", err)
+			return fmt.Errorf("update manifest: %w", err)
 		}
+		files = append(files, manifest...)
+	}
 
-		if err := os.WriteFile(filepath.Join(outputDir, templateFileName), constraintTemplate, 0644); err != nil {
-			return fmt.Errorf("writing ConstraintTemplate: %w", err)
+	if viper.GetBool("check") {
+		// Keep the diagnostics on stdout parseable.
+		out := os.Stdout
+		if format != "" {
+			out = os.Stderr
 		}
+		if err := checkFiles(files, out); err != nil {
+			return errors.Join(reportErr, err)
+		}
+		return reportErr
+	}
 
-		if viper.GetBool("skip-constraints") || violation.SkipConstraint() {
-			logger.Info("Skipping constraint generation due to configuration")
-			continue
+	if err := writeFiles(files); err != nil {
+		return fmt.Errorf("write files: %w", err)
+	}
+
+	if reportErr != nil {
+		log.WithField("num_policies", rendered).Warn("generated the resources of the valid policies")
+		return reportErr
+	}
+
+	log.WithField("num_policies", len(violations)).Info("completed successfully")
+
+	return nil
+}
+
+// renderPolicy renders the ConstraintTemplate and Constraints of a policy, or
+// its ValidatingAdmissionPolicy with the vap target.
+func renderPolicy(violation rego.Rego, logger *log.Entry, diagnostics *diagnostic.List) ([]generatedFile, error) {
+	if violation.SkipTemplate() {
+		logger.Info("Skipping constrainttemplate generation due to configuration")
+		return nil, nil
+	}
+
+	if !isValidEnforcementAction(violation.Enforcement()) {
+		return nil, diagnostic.List{diagnostic.Errorf(diagnostic.CodeInvalidEnforcement, violation.Location(), "enforcement action (%v) is invalid in policy: %s", violation.Enforcement(), violation.Path())}
+	}
+
+	if viper.GetString("target") == targetVAP {
+		return renderValidatingAdmissionPolicy(violation, logger, diagnostics)
+	}
+
+	templateFileName := "template.yaml"
+	constraintFileName := "constraint.yaml"
+	outputDir := filepath.Dir(violation.Path())
+	if viper.GetString("output") != "" {
+		outputDir = viper.GetString("output")
+		templateFileName = fmt.Sprintf("template_%s.yaml", violation.Kind())
+		constraintFileName = fmt.Sprintf("constraint_%s.yaml", violation.Kind())
+	}
+
+	constraintTemplateVersion := viper.GetString("constraint-template-version")
+	constraintTemplateCustomTemplateFile := viper.GetString("constraint-template-custom-template-file")
+
+	if constraintTemplateCustomTemplateFile == "" && constraintTemplateVersion != "v1" && setsGenerateVAP(violation) {
+		return nil, diagnostic.List{diagnostic.Errorf(diagnostic.CodeInvalidAnnotation, violation.Location(), "generateVAP requires ConstraintTemplate version v1, not %s", constraintTemplateVersion)}
+	}
+
+	constraintTemplate, err := renderConstraintTemplate(violation, constraintTemplateVersion, constraintTemplateCustomTemplateFile, logger)
+	if err != nil {
+		return nil, fmt.Errorf("rendering ConstraintTemplate: %w", err)
+	}
+
+	files := []generatedFile{{path: filepath.Join(outputDir, templateFileName), content: constraintTemplate}}
+
+	if viper.GetBool("skip-constraints") || violation.SkipConstraint() {
+		logger.Info("Skipping constraint generation due to configuration")
+		return files, nil
+	}
+
+	constraintCustomTemplateFile := viper.GetString("constraint-custom-template-file")
+
+	// Render one Constraint for each instance declared in the metadata.
+	if len(violation.AnnotationConstraints()) > 0 {
+		for _, c := range violation.AnnotationConstraints() {
+			instance, err := violation.ForConstraint(c)
+			if err != nil {
+				return nil, fmt.Errorf("get constraint %s: %w", c.Name, err)
+			}
+
+			if !isValidEnforcementAction(instance.Enforcement()) {
+				return nil, diagnostic.List{diagnostic.Errorf(diagnostic.CodeInvalidEnforcement, violation.Location(), "enforcement action (%v) of constraint %s is invalid in policy: %s", instance.Enforcement(), c.Name, violation.Path())}
+			}
+
+			constraintBytes, err := renderConstraint(instance, constraintCustomTemplateFile, logger, diagnostics)
+			if err != nil {
+				return nil, fmt.Errorf("rendering Constraint %s: %w", c.Name, err)
+			}
+
+			instanceFileName := fmt.Sprintf("constraint_%s.yaml", c.Name)
+			if viper.GetString("output") != "" {
+				instanceFileName = fmt.Sprintf("constraint_%s_%s.yaml", violation.Kind(), c.Name)
+			}
+			files = append(files, generatedFile{path: filepath.Join(outputDir, instanceFileName), content: constraintBytes})
+		}
+		return files, nil
+	}
+
+	// Skip Constraint generation if there are parameters on the template.
+	if !viper.GetBool("partial-constraints") && len(violation.AnnotationParameters()) > 0 {
+		logger.Warn("Skipping constraint generation due to use of parameters")
+		return files, nil
+	}
+
+	constraintBytes, err := renderConstraint(violation, constraintCustomTemplateFile, logger, diagnostics)
+	if err != nil {
+		return nil, fmt.Errorf("rendering Constraint: %w", err)
+	}
+
+	return append(files, generatedFile{path: filepath.Join(outputDir, constraintFileName), content: constraintBytes}), nil
+}
+
+// Annotations of a ConstraintTemplate that Gatekeeper and the
+// gatekeeper-library use.
+const (
+	requiresSyncDataAnnotation = "metadata.gatekeeper.sh/requires-sync-data"
+	titleAnnotation            = "metadata.gatekeeper.sh/title"
+	versionAnnotation          = "metadata.gatekeeper.sh/version"
+	descriptionAnnotation      = "description"
+)
+
+// getTemplateAnnotations returns the annotations of the ConstraintTemplate of
+// the policy. The annotations set in custom.templateAnnotations take
+// precedence over the ones that are generated.
+func getTemplateAnnotations(violation rego.Rego) map[string]string {
+	annotations := make(map[string]string)
+	if viper.GetBool("library-annotations") {
+		if violation.Title() != "" {
+			annotations[titleAnnotation] = violation.Title()
+		}
+		if violation.Version() != "" {
+			annotations[versionAnnotation] = violation.Version()
+		}
+		if violation.Description() != "" {
+			annotations[descriptionAnnotation] = violation.Description()
+		}
+	}
+
+	if syncData := violation.RequiresSyncData(); len(syncData) > 0 {
+		// The value is JSON, which cannot fail to marshal for these types.
+		value, _ := json.Marshal(syncData)
+		annotations[requiresSyncDataAnnotation] = string(value)
+	}
+
+	for key, value := range violation.TemplateAnnotations() {
+		annotations[key] = value
+	}
+
+	if len(annotations) == 0 {
+		return nil
+	}
+
+	return annotations
+}
+
+// syncResourceKinds are the kinds of the Gatekeeper resources that can be
+// created to replicate resources into data.inventory.
+var syncResourceKinds = []string{"config", "syncset"}
+
+// renderSyncResource renders a Gatekeeper Config or SyncSet that replicates
+// every resource of the requirements into data.inventory. The namespace is
+// only set on the Config, as the SyncSet is cluster scoped.
+func renderSyncResource(kind, namespace string, requirements [][]rego.SyncData, outputDir string) (generatedFile, error) {
+	seen := make(map[rego.GVK]bool)
+	gvks := []rego.GVK{}
+	for _, requirement := range requirements {
+		for _, syncData := range requirement {
+			for _, gvk := range syncData.GVKs() {
+				if !seen[gvk] {
+					seen[gvk] = true
+					gvks = append(gvks, gvk)
+				}
+			}
+		}
+	}
+	sort.Slice(gvks, func(i, j int) bool {
+		if gvks[i].Group != gvks[j].Group {
+			return gvks[i].Group < gvks[j].Group
+		}
+		if gvks[i].Version != gvks[j].Version {
+			return gvks[i].Version < gvks[j].Version
+		}
+		return gvks[i].Kind < gvks[j].Kind
+	})
+
+	var resource map[string]any
+	switch kind {
+	case "config":
+		// Gatekeeper only reads the Config named config in the namespace it
+		// is installed in.
+		resource = map[string]any{
+			"apiVersion": "config.gatekeeper.sh/v1alpha1",
+			"kind":       "Config",
+			"metadata":   map[string]any{"name": "config", "namespace": namespace},
+			"spec":       map[string]any{"sync": map[string]any{"syncOnly": gvks}},
+		}
+	case "syncset":
+		resource = map[string]any{
+			"apiVersion": "syncset.gatekeeper.sh/v1alpha1",
+			"kind":       "SyncSet",
+			"metadata":   map[string]any{"name": "konstraint"},
+			"spec":       map[string]any{"gvks": gvks},
+		}
+	default:
+		return generatedFile{}, fmt.Errorf("unknown sync resource %q", kind)
+	}
+
+	content, err := yaml.Marshal(resource)
+	if err != nil {
+		return generatedFile{}, fmt.Errorf("marshal %s: %w", kind, err)
+	}
+
+	return generatedFile{path: filepath.Join(outputDir, "sync.yaml"), content: content}, nil
+}
+
+// checkConflicts returns a diagnostic for every set of policies that would
+// generate resources with the same kind or name, or write them to the same
+// files, as they would silently overwrite each other.
+func checkConflicts(violations []rego.Rego, outputDir string) error {
+	var keys []string
+	sources := make(map[string][]string)
+	locations := make(map[string]*ast.Location)
+	for _, violation := range violations {
+		locations[violation.Path()] = violation.Location()
+	}
+	add := func(key string, path string) {
+		if _, ok := sources[key]; !ok {
+			keys = append(keys, key)
 		}
+		sources[key] = append(sources[key], path)
+	}
 
-		// Skip Constraint generation if there are parameters on the template.
-		if !viper.GetBool("partial-constraints") && len(violation.AnnotationParameters()) > 0 {
-			logger.Warn("Skipping constraint generation due to use of parameters")
+	for _, violation := range violations {
+		if violation.SkipTemplate() {
 			continue
 		}
 
-		constraintCustomTemplateFile := viper.GetString("constraint-custom-template-file")
+		// Gatekeeper derives the name of the ConstraintTemplate from the kind,
+		// so kinds that only differ in casing conflict as well.
+		add("kind "+strings.ToLower(violation.Kind()), violation.Path())
 
-		constraintBytes, err := renderConstraint(violation, constraintCustomTemplateFile, logger)
+		if outputDir == "" {
+			add("directory "+filepath.Dir(violation.Path()), violation.Path())
+		}
 
-		if err != nil {
-			return fmt.Errorf("rendering Constraint: %w", err)
+		if len(violation.AnnotationConstraints()) == 0 {
+			add("constraint name "+violation.Name(), violation.Path())
+			continue
 		}
-		if err := os.WriteFile(filepath.Join(outputDir, constraintFileName), constraintBytes, 0644); err != nil {
-			return fmt.Errorf("writing constraint: %w", err)
+		for _, c := range violation.AnnotationConstraints() {
+			instance, err := violation.ForConstraint(c)
+			if err != nil {
+				return fmt.Errorf("get constraint %s: %w", c.Name, err)
+			}
+			add("constraint name "+instance.Name(), violation.Path())
 		}
 	}
 
-	log.WithField("num_policies", len(violations)).Info("completed successfully")
+	var conflicts diagnostic.List
+	for _, key := range keys {
+		// The conflict is reported at every policy but the first, which
+		// keeps its resources when the other policies are skipped.
+		for _, source := range sources[key][1:] {
+			conflicts.Add(diagnostic.Errorf(diagnostic.CodeResourceConflict, locations[source], "%s: %s", key, strings.Join(sources[key], ", ")))
+		}
+	}
 
-	return nil
+	return conflicts.Err()
 }
 
 func renderConstraintTemplate(violation rego.Rego, constraintTemplateVersion string, constraintTemplateCustomTemplateFile string, logger *log.Entry) ([]byte, error) {
@@ -182,6 +572,9 @@ func renderConstraintTemplate(violation rego.Rego, constraintTemplateVersion str
 		if err != nil {
 			return nil, fmt.Errorf("unable to render custom template: %w", err)
 		}
+		if len(violation.AnnotationOperations()) > 0 && !setsOperations(constraintTemplateBytes) {
+			logger.Warn("The custom template does not set the operations of the policy, use .AnnotationOperations to render them")
+		}
 	} else {
 		switch constraintTemplateVersion {
 		case "v1":
@@ -202,7 +595,7 @@ func renderConstraintTemplate(violation rego.Rego, constraintTemplateVersion str
 	return constraintTemplateBytes, nil
 
 }
-func renderConstraint(violation rego.Rego, constraintCustomTemplateFile string, logger *log.Entry) ([]byte, error) {
+func renderConstraint(violation rego.Rego, constraintCustomTemplateFile string, logger *log.Entry, diagnostics *diagnostic.List) ([]byte, error) {
 	var constraintBytes []byte
 	if constraintCustomTemplateFile != "" {
 		customTemplate, err := os.ReadFile(constraintCustomTemplateFile)
@@ -223,6 +616,13 @@ func renderConstraint(violation rego.Rego, constraintCustomTemplateFile string,
 		if err != nil {
 			return nil, fmt.Errorf("marshal constraint: %w", err)
 		}
+
+		unset := getUnsetParameters(constraint)
+		for _, path := range unset {
+			parameter := strings.Join(path[2:], ".")
+			diagnostics.Add(diagnostic.Warningf(diagnostic.CodeUnsetParameter, violation.Location(), "Unable to determine a value for parameter %s", parameter))
+		}
+		constraintBytes = commentUnsetParameters(constraintBytes, unset)
 	}
 	return constraintBytes, nil
 
@@ -249,7 +649,9 @@ func getConstraintTemplatev1(violation rego.Rego, _ *log.Entry) *v1.ConstraintTe
 			Kind:       "ConstraintTemplate",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name: violation.Name(),
+			Name:        violation.TemplateName(),
+			Annotations: getTemplateAnnotations(violation),
+			Labels:      violation.TemplateLabels(),
 		},
 		Spec: v1.ConstraintTemplateSpec{
 			CRD: v1.CRD{
@@ -261,9 +663,10 @@ func getConstraintTemplatev1(violation rego.Rego, _ *log.Entry) *v1.ConstraintTe
 			},
 			Targets: []v1.Target{
 				{
-					Target: "admission.k8s.gatekeeper.sh",
-					Libs:   violation.Dependencies(),
-					Rego:   violation.Source(),
+					Target:     "admission.k8s.gatekeeper.sh",
+					Libs:       violation.Dependencies(),
+					Rego:       violation.Source(),
+					Operations: getOperations(violation.AnnotationOperations()),
 				},
 			},
 		},
@@ -278,6 +681,10 @@ func getConstraintTemplatev1(violation rego.Rego, _ *log.Entry) *v1.ConstraintTe
 		}
 	}
 
+	if source := getCELSource(violation); source != nil {
+		constraintTemplate.Spec.Targets[0].Code = []v1.Code{{Engine: rego.CELEngine, Source: source}}
+	}
+
 	return &constraintTemplate
 }
 
@@ -288,7 +695,9 @@ func getConstraintTemplatev1beta1(violation rego.Rego, _ *log.Entry) *v1beta1.Co
 			Kind:       "ConstraintTemplate",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name: violation.Name(),
+			Name:        violation.TemplateName(),
+			Annotations: getTemplateAnnotations(violation),
+			Labels:      violation.TemplateLabels(),
 		},
 		Spec: v1beta1.ConstraintTemplateSpec{
 			CRD: v1beta1.CRD{
@@ -300,9 +709,10 @@ func getConstraintTemplatev1beta1(violation rego.Rego, _ *log.Entry) *v1beta1.Co
 			},
 			Targets: []v1beta1.Target{
 				{
-					Target: "admission.k8s.gatekeeper.sh",
-					Libs:   violation.Dependencies(),
-					Rego:   violation.Source(),
+					Target:     "admission.k8s.gatekeeper.sh",
+					Libs:       violation.Dependencies(),
+					Rego:       violation.Source(),
+					Operations: getOperations(violation.AnnotationOperations()),
 				},
 			},
 		},
@@ -316,6 +726,10 @@ func getConstraintTemplatev1beta1(violation rego.Rego, _ *log.Entry) *v1beta1.Co
 		}
 	}
 
+	if source := getCELSource(violation); source != nil {
+		constraintTemplate.Spec.Targets[0].Code = []v1beta1.Code{{Engine: rego.CELEngine, Source: source}}
+	}
+
 	return &constraintTemplate
 }
 
@@ -334,6 +748,18 @@ func getConstraint(violation rego.Rego, _ *log.Entry) (*unstructured.Unstructure
 		constraint.SetAnnotations(annotations)
 	}
 	labels := violation.Labels()
+	if generateVAP := getGenerateVAP(violation); generateVAP != nil {
+		useVAP := "no"
+		if *generateVAP {
+			useVAP = "yes"
+		}
+
+		labels = maps.Clone(labels)
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[useVAPLabel] = useVAP
+	}
 	if labels != nil {
 		constraint.SetLabels(labels)
 	}
@@ -344,22 +770,40 @@ func getConstraint(violation rego.Rego, _ *log.Entry) (*unstructured.Unstructure
 		}
 	}
 
+	if violation.Enforcement() == "scoped" {
+		scopedActions, err := toUnstructured(violation.ScopedEnforcementActions())
+		if err != nil {
+			return nil, fmt.Errorf("convert scoped enforcement actions: %w", err)
+		}
+		if err := unstructured.SetNestedField(constraint.Object, scopedActions, "spec", "scopedEnforcementActions"); err != nil {
+			return nil, fmt.Errorf("set constraint scoped enforcement actions: %w", err)
+		}
+	}
+
 	// The dryrun flag overrides any enforcement action specified in the rego header.
 	dryrun := viper.GetBool("dryrun")
 	if dryrun {
 		if err := unstructured.SetNestedField(constraint.Object, "dryrun", "spec", "enforcementAction"); err != nil {
 			return nil, fmt.Errorf("set constraint dryrun: %w", err)
 		}
+		unstructured.RemoveNestedField(constraint.Object, "spec", "scopedEnforcementActions")
 	}
 
-	metadataMatchers, err := violation.GetAnnotation("matchers")
-	if err == nil {
-		if err := unstructured.SetNestedField(constraint.Object, metadataMatchers, "spec", "match"); err != nil {
+	if _, err := violation.GetAnnotation("matchers"); err == nil {
+		matchers, err := toUnstructured(violation.AnnotationMatchers())
+		if err != nil {
+			return nil, fmt.Errorf("convert matchers: %w", err)
+		}
+		if err := unstructured.SetNestedField(constraint.Object, matchers, "spec", "match"); err != nil {
 			return nil, fmt.Errorf("set matchers from metadata annotation: %w", err)
 		}
 	}
 
-	if viper.GetBool("partial-constraints") {
+	if violation.ConstraintParameters() != nil {
+		if err := unstructured.SetNestedField(constraint.Object, violation.ConstraintParameters(), "spec", "parameters"); err != nil {
+			return nil, fmt.Errorf("set constraint parameters: %w", err)
+		}
+	} else if viper.GetBool("partial-constraints") {
 		if len(violation.AnnotationParameters()) > 0 {
 			if err := addParametersToConstraint(&constraint, violation.AnnotationParameters()); err != nil {
 				return nil, fmt.Errorf("add parameters %v to constraint: %w", violation.AnnotationParameters(), err)
@@ -372,8 +816,12 @@ func getConstraint(violation rego.Rego, _ *log.Entry) (*unstructured.Unstructure
 
 func addParametersToConstraint(constraint *unstructured.Unstructured, parameters map[string]apiextensionsv1.JSONSchemaProps) error {
 	params := make(map[string]any, len(parameters))
-	for p := range parameters {
-		params[p] = nil
+	for p, schema := range parameters {
+		value, err := getParameterValue(schema)
+		if err != nil {
+			return fmt.Errorf("get value of parameter %s: %w", p, err)
+		}
+		params[p] = value
 	}
 	if err := unstructured.SetNestedField(constraint.Object, params, "spec", "parameters"); err != nil {
 		return fmt.Errorf("set parameters map: %w", err)
@@ -382,8 +830,240 @@ func addParametersToConstraint(constraint *unstructured.Unstructured, parameters
 	return nil
 }
 
+// getParameterValue returns a placeholder value for a parameter of a partial
+// Constraint. The default of the schema is used first, then the example, and
+// finally the zero value of its type. A nil value is returned when none of
+// them can be determined.
+func getParameterValue(schema apiextensionsv1.JSONSchemaProps) (any, error) {
+	for _, raw := range []*apiextensionsv1.JSON{schema.Default, schema.Example} {
+		if raw == nil {
+			continue
+		}
+
+		var value any
+		if err := json.Unmarshal(raw.Raw, &value); err != nil {
+			return nil, fmt.Errorf("unmarshal value: %w", err)
+		}
+		return value, nil
+	}
+
+	switch schema.Type {
+	case "string":
+		return "", nil
+	case "integer":
+		return int64(0), nil
+	case "number":
+		return float64(0), nil
+	case "boolean":
+		return false, nil
+	case "array":
+		if schema.Items == nil || schema.Items.Schema == nil {
+			return []any{}, nil
+		}
+
+		// Only use an item when the schema describes one, as the zero value of
+		// an array is empty.
+		items := schema.Items.Schema
+		if items.Default == nil && items.Example == nil {
+			return []any{}, nil
+		}
+		item, err := getParameterValue(*items)
+		if err != nil {
+			return nil, fmt.Errorf("get value of items: %w", err)
+		}
+		return []any{item}, nil
+	case "object":
+		object := make(map[string]any, len(schema.Properties))
+		for name, property := range schema.Properties {
+			value, err := getParameterValue(property)
+			if err != nil {
+				return nil, fmt.Errorf("get value of property %s: %w", name, err)
+			}
+			object[name] = value
+		}
+		return object, nil
+	}
+
+	return nil, nil
+}
+
+// getUnsetParameters returns the paths of all parameters of the Constraint
+// that do not have a value.
+func getUnsetParameters(constraint *unstructured.Unstructured) [][]string {
+	parameters, ok, _ := unstructured.NestedMap(constraint.Object, "spec", "parameters")
+	if !ok {
+		return nil
+	}
+
+	var unset [][]string
+	var walk func(path []string, object map[string]any)
+	walk = func(path []string, object map[string]any) {
+		for name, value := range object {
+			current := append(append([]string{}, path...), name)
+			switch v := value.(type) {
+			case nil:
+				unset = append(unset, current)
+			case map[string]any:
+				walk(current, v)
+			}
+		}
+	}
+	walk([]string{"spec", "parameters"}, parameters)
+
+	sort.Slice(unset, func(i, j int) bool {
+		return strings.Join(unset[i], ".") < strings.Join(unset[j], ".")
+	})
+	return unset
+}
+
+// commentUnsetParameters adds a comment to the lines of the rendered YAML
+// that contain the given parameter paths, so that they are easy to find when
+// filling in the values of a partial Constraint.
+func commentUnsetParameters(constraint []byte, unset [][]string) []byte {
+	if len(unset) == 0 {
+		return constraint
+	}
+
+	targets := make(map[string]bool, len(unset))
+	for _, path := range unset {
+		targets[strings.Join(path, ".")] = true
+	}
+
+	type key struct {
+		indent int
+		name   string
+	}
+	var stack []key
+
+	lines := strings.Split(string(constraint), "\n")
+	for i, line := range lines {
+		match := yamlKeyRE.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		indent := len(match[1])
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		stack = append(stack, key{indent: indent, name: match[2]})
+
+		path := make([]string, len(stack))
+		for j := range stack {
+			path[j] = stack[j].name
+		}
+		if targets[strings.Join(path, ".")] {
+			lines[i] = line + " # TODO: set a value for this parameter"
+		}
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// setsOperations returns whether a target of the rendered ConstraintTemplate
+// sets its operations. A template that is not valid YAML, such as a template
+// for Helm, is assumed to set them.
+func setsOperations(constraintTemplate []byte) bool {
+	var template unstructured.Unstructured
+	if err := yaml.Unmarshal(constraintTemplate, &template.Object); err != nil {
+		return true
+	}
+
+	targets, _, _ := unstructured.NestedSlice(template.Object, "spec", "targets")
+	for _, target := range targets {
+		if t, ok := target.(map[string]any); ok && t["operations"] != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getOperations returns the admission operations of the operations
+// annotation, or nil when it is unset.
+func getOperations(operations []string) []admissionregistrationv1.OperationType {
+	if len(operations) == 0 {
+		return nil
+	}
+
+	types := make([]admissionregistrationv1.OperationType, len(operations))
+	for i, o := range operations {
+		types[i] = admissionregistrationv1.OperationType(o)
+	}
+
+	return types
+}
+
+// getCELSource returns the source of the K8sNativeValidation engine of the
+// ConstraintTemplate of the policy, or nil when the policy has no CEL.
+func getCELSource(violation rego.Rego) *templates.Anything {
+	if violation.CEL() == nil {
+		return nil
+	}
+
+	// The CEL source is JSON, which cannot fail to convert.
+	value, _ := toUnstructured(violation.CEL())
+	if generateVAP := getGenerateVAP(violation); generateVAP != nil {
+		value.(map[string]any)["generateVAP"] = *generateVAP
+	}
+
+	return &templates.Anything{Value: value}
+}
+
+// setsGenerateVAP returns whether the policy or any of its Constraints sets
+// generateVAP.
+func setsGenerateVAP(violation rego.Rego) bool {
+	if violation.GenerateVAP() != nil {
+		return true
+	}
+	for _, c := range violation.AnnotationConstraints() {
+		if c.GenerateVAP != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// useVAPLabel is the label of a Constraint that tells Gatekeeper whether to
+// generate a ValidatingAdmissionPolicyBinding for it.
+const useVAPLabel = "gatekeeper.sh/use-vap"
+
+// getGenerateVAP returns whether Gatekeeper should generate a
+// ValidatingAdmissionPolicy for the policy, or a binding for the Constraint
+// the Rego describes. The generateVAP annotation takes precedence over the
+// generate-vap flag, which only applies to policies with CEL. It is nil when
+// neither is set.
+func getGenerateVAP(violation rego.Rego) *bool {
+	if violation.GenerateVAP() != nil {
+		return violation.GenerateVAP()
+	}
+	if viper.GetBool("generate-vap") && violation.CEL() != nil {
+		generateVAP := true
+		return &generateVAP
+	}
+
+	return nil
+}
+
+// toUnstructured converts the value to its unstructured representation, so it
+// can be set as a field of an unstructured object.
+func toUnstructured(v any) (any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+
+	var result any
+	if err := json.Unmarshal(b, &result); err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	return result, nil
+}
+
 func isValidEnforcementAction(action string) bool {
-	for _, a := range []string{"deny", "dryrun", "warn"} {
+	for _, a := range []string{"deny", "dryrun", "warn", "scoped"} {
 		if a == action {
 			return true
 		}
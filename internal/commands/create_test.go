@@ -3,10 +3,17 @@ package commands
 import (
 	"bytes"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
 
 	"github.com/plexsystems/konstraint/internal/rego"
 )
@@ -27,7 +34,7 @@ func TestRenderConstraint(t *testing.T) {
 	// Need to remove carriage return for testing on Windows
 	expected = bytes.ReplaceAll(expected, []byte("\r"), []byte(""))
 
-	actual, err := renderConstraint(violations[0], "", entry.LastEntry())
+	actual, err := renderConstraint(violations[0], "", entry.LastEntry(), nil)
 	if err != nil {
 		t.Errorf("Error rendering constraint: %v", err)
 	}
@@ -56,7 +63,7 @@ func TestRenderConstraintWithCustomTemplate(t *testing.T) {
 	// Need to remove carriage return for testing on Windows
 	expected = bytes.ReplaceAll(expected, []byte("\r"), []byte(""))
 
-	actual, err := renderConstraint(violations[0], "constraint_template.tpl", entry.LastEntry())
+	actual, err := renderConstraint(violations[0], "constraint_template.tpl", entry.LastEntry(), nil)
 	if err != nil {
 		t.Errorf("Error rendering constraint: %v", err)
 	}
@@ -128,6 +135,145 @@ func TestRenderConstraintTemplateWithCustomTemplate(t *testing.T) {
 	}
 }
 
+func TestGetParameterValue(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		schema apiextensionsv1.JSONSchemaProps
+		want   any
+	}{
+		{
+			desc:   "Default",
+			schema: apiextensionsv1.JSONSchemaProps{Type: "string", Default: &apiextensionsv1.JSON{Raw: []byte(`"foo"`)}, Example: &apiextensionsv1.JSON{Raw: []byte(`"bar"`)}},
+			want:   "foo",
+		},
+		{
+			desc:   "Example",
+			schema: apiextensionsv1.JSONSchemaProps{Type: "string", Example: &apiextensionsv1.JSON{Raw: []byte(`"bar"`)}},
+			want:   "bar",
+		},
+		{
+			desc:   "Integer",
+			schema: apiextensionsv1.JSONSchemaProps{Type: "integer"},
+			want:   int64(0),
+		},
+		{
+			desc:   "Array without item values",
+			schema: apiextensionsv1.JSONSchemaProps{Type: "array", Items: &apiextensionsv1.JSONSchemaPropsOrArray{Schema: &apiextensionsv1.JSONSchemaProps{Type: "string"}}},
+			want:   []any{},
+		},
+		{
+			desc:   "Array with item example",
+			schema: apiextensionsv1.JSONSchemaProps{Type: "array", Items: &apiextensionsv1.JSONSchemaPropsOrArray{Schema: &apiextensionsv1.JSONSchemaProps{Type: "string", Example: &apiextensionsv1.JSON{Raw: []byte(`"foo"`)}}}},
+			want:   []any{"foo"},
+		},
+		{
+			desc: "Nested object",
+			schema: apiextensionsv1.JSONSchemaProps{Type: "object", Properties: map[string]apiextensionsv1.JSONSchemaProps{
+				"enabled": {Type: "boolean"},
+				"any":     {XPreserveUnknownFields: func() *bool { b := true; return &b }()},
+			}},
+			want: map[string]any{"enabled": false, "any": nil},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			actual, err := getParameterValue(tc.schema)
+			if err != nil {
+				t.Fatalf("get parameter value: %s", err)
+			}
+
+			if diff := cmp.Diff(tc.want, actual); diff != "" {
+				t.Errorf("unexpected parameter value:\n %v", diff)
+			}
+		})
+	}
+}
+
+func TestCommentUnsetParameters(t *testing.T) {
+	constraint := unstructured.Unstructured{Object: map[string]any{
+		"spec": map[string]any{
+			"parameters": map[string]any{
+				"name": "foo",
+				"any":  nil,
+				"object": map[string]any{
+					"any": nil,
+				},
+			},
+		},
+	}}
+
+	input := `spec:
+  parameters:
+    any: null
+    name: foo
+    object:
+      any: null
+`
+	expected := `spec:
+  parameters:
+    any: null # TODO: set a value for this parameter
+    name: foo
+    object:
+      any: null # TODO: set a value for this parameter
+`
+
+	actual := commentUnsetParameters([]byte(input), getUnsetParameters(&constraint))
+	if diff := cmp.Diff(expected, string(actual)); diff != "" {
+		t.Errorf("unexpected rendered constraint:\n %v", diff)
+	}
+}
+
+func TestCheckConflicts(t *testing.T) {
+	policy := func(pkg string) []byte {
+		return []byte("package " + pkg + "\n\nviolation[msg] {\n  msg := \"foo\"\n}\n")
+	}
+
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"pod-deny-x/src.rego":   policy("pod_deny_x"),
+		"pod_deny_x/src.rego":   policy("pod_deny_x_2"),
+		"pod-deny-y/src.rego":   policy("pod_deny_y"),
+		"pod-deny-y/other.rego": policy("pod_deny_y_2"),
+		"pod-deny-z/src.rego":   policy("pod_deny_z"),
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), os.ModePerm); err != nil {
+			t.Fatalf("create dir: %s", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatalf("write file: %s", err)
+		}
+	}
+
+	violations, err := rego.GetViolations(dir)
+	if err != nil {
+		t.Fatalf("get violations: %s", err)
+	}
+
+	err = checkConflicts(violations, "")
+	if err == nil {
+		t.Fatalf("expected conflicts, got none")
+	}
+
+	for _, expected := range []string{
+		"kind poddenyx: " + filepath.Join(dir, "pod-deny-x/src.rego") + ", " + filepath.Join(dir, "pod_deny_x/src.rego"),
+		"kind poddenyy: " + filepath.Join(dir, "pod-deny-y/other.rego") + ", " + filepath.Join(dir, "pod-deny-y/src.rego"),
+		"directory " + filepath.Join(dir, "pod-deny-y") + ": ",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q, actual %q", expected, err.Error())
+		}
+	}
+	if strings.Contains(err.Error(), "poddenyz") {
+		t.Errorf("unexpected conflict for poddenyz: %q", err.Error())
+	}
+
+	if err := checkConflicts(violations[:1], ""); err != nil {
+		t.Errorf("unexpected error for a single policy: %s", err)
+	}
+}
+
 func GetViolations() ([]rego.Rego, error) {
 	violations, err := rego.GetViolations("../../test/policies/")
 	if err != nil {
@@ -135,3 +281,467 @@ func GetViolations() ([]rego.Rego, error) {
 	}
 	return violations, nil
 }
+
+func TestRunCreateCommandKeepGoing(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"pod-deny-valid/src.rego": "package pod_deny_valid\n\nviolation[msg] {\n  msg := \"foo\"\n}\n",
+		"pod-deny-enforcement/src.rego": `# METADATA
+# custom:
+#   enforcement: block
+package pod_deny_enforcement
+
+violation[msg] {
+  msg := "foo"
+}
+`,
+		"pod-deny-unparsable/src.rego": "package pod_deny_unparsable\n\nviolation[msg] {\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), os.ModePerm); err != nil {
+			t.Fatalf("create dir: %s", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("write file: %s", err)
+		}
+	}
+
+	testCases := []struct {
+		desc      string
+		keepGoing bool
+		generated bool
+	}{
+		{desc: "Abort", keepGoing: false, generated: false},
+		{desc: "Keep going", keepGoing: true, generated: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Cleanup(viper.Reset)
+
+			output := t.TempDir()
+			viper.Set("output", output)
+			viper.Set("constraint-template-version", "v1")
+			viper.Set("keep-going", tc.keepGoing)
+
+			err := runCreateCommand(dir)
+			if err == nil {
+				t.Fatal("expected error, got none")
+			}
+			for _, expected := range []string{"(invalid-enforcement)", "(rego-parse)"} {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("expected error to contain %q, actual %q", expected, err.Error())
+				}
+			}
+
+			_, err = os.Stat(filepath.Join(output, "template_PodDenyValid.yaml"))
+			if generated := err == nil; generated != tc.generated {
+				t.Errorf("unexpected generation of the valid policy. expected %v, actual %v", tc.generated, generated)
+			}
+			if _, err := os.Stat(filepath.Join(output, "template_PodDenyEnforcement.yaml")); err == nil {
+				t.Error("expected the policy with an invalid enforcement not to be generated")
+			}
+		})
+	}
+}
+
+func TestRunCreateCommandSyncData(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"ingress-deny-duplicate-host/src.rego": `package ingress_deny_duplicate_host
+
+violation[msg] {
+  data.inventory.namespace[_]["networking.k8s.io/v1"].Ingress[_]
+  msg := "foo"
+}
+`,
+		"service-deny-selector/src.rego": `# METADATA
+# custom:
+#   requiresSyncData:
+#   - - groups: [""]
+#       versions: ["v1"]
+#       kinds: ["Pod"]
+#     - groups: ["apps"]
+#       versions: ["v1"]
+#       kinds: ["Deployment"]
+package service_deny_selector
+
+violation[msg] {
+  data.inventory.namespace[_][_][_][_]
+  msg := "foo"
+}
+`,
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), os.ModePerm); err != nil {
+			t.Fatalf("create dir: %s", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("write file: %s", err)
+		}
+	}
+
+	t.Cleanup(viper.Reset)
+	output := t.TempDir()
+	viper.Set("output", output)
+	viper.Set("constraint-template-version", "v1")
+	viper.Set("sync-resource", "syncset")
+
+	if err := runCreateCommand(dir); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expectedAnnotations := map[string]string{
+		"template_IngressDenyDuplicateHost.yaml": `[[{"groups":["networking.k8s.io"],"versions":["v1"],"kinds":["Ingress"]}]]`,
+		"template_ServiceDenySelector.yaml":      `[[{"groups":[""],"versions":["v1"],"kinds":["Pod"]},{"groups":["apps"],"versions":["v1"],"kinds":["Deployment"]}]]`,
+	}
+	for name, expected := range expectedAnnotations {
+		content, err := os.ReadFile(filepath.Join(output, name))
+		if err != nil {
+			t.Fatalf("read template: %s", err)
+		}
+
+		var template unstructured.Unstructured
+		if err := yaml.Unmarshal(content, &template.Object); err != nil {
+			t.Fatalf("unmarshal template: %s", err)
+		}
+		if actual := template.GetAnnotations()[requiresSyncDataAnnotation]; actual != expected {
+			t.Errorf("unexpected annotation of %s. expected %s, actual %s", name, expected, actual)
+		}
+	}
+
+	content, err := os.ReadFile(filepath.Join(output, "sync.yaml"))
+	if err != nil {
+		t.Fatalf("read sync resource: %s", err)
+	}
+	expected := `apiVersion: syncset.gatekeeper.sh/v1alpha1
+kind: SyncSet
+metadata:
+  name: konstraint
+spec:
+  gvks:
+  - group: ""
+    kind: Pod
+    version: v1
+  - group: apps
+    kind: Deployment
+    version: v1
+  - group: networking.k8s.io
+    kind: Ingress
+    version: v1
+`
+	if diff := cmp.Diff(expected, string(content)); diff != "" {
+		t.Errorf("unexpected sync resource (-want +got):\n%s", diff)
+	}
+}
+
+func TestRenderSyncResourceConfig(t *testing.T) {
+	requirements := [][]rego.SyncData{{{Groups: []string{""}, Versions: []string{"v1"}, Kinds: []string{"Namespace"}}}}
+
+	file, err := renderSyncResource("config", "security", requirements, "output")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := `apiVersion: config.gatekeeper.sh/v1alpha1
+kind: Config
+metadata:
+  name: config
+  namespace: security
+spec:
+  sync:
+    syncOnly:
+    - group: ""
+      kind: Namespace
+      version: v1
+`
+	if diff := cmp.Diff(expected, string(file.content)); diff != "" {
+		t.Errorf("unexpected sync resource (-want +got):\n%s", diff)
+	}
+	if expected := filepath.Join("output", "sync.yaml"); file.path != expected {
+		t.Errorf("unexpected path. expected %s, actual %s", expected, file.path)
+	}
+}
+
+func TestGetTemplateAnnotations(t *testing.T) {
+	violations, err := GetViolations()
+	if err != nil {
+		t.Fatalf("get violations: %s", err)
+	}
+
+	testCases := []struct {
+		desc               string
+		libraryAnnotations bool
+		expected           map[string]string
+	}{
+		{
+			desc: "Template annotations",
+			expected: map[string]string{
+				"argocd.argoproj.io/sync-wave": "-1",
+			},
+		},
+		{
+			desc:               "Library annotations",
+			libraryAnnotations: true,
+			expected: map[string]string{
+				"argocd.argoproj.io/sync-wave":   "-1",
+				"metadata.gatekeeper.sh/title":   "The title",
+				"metadata.gatekeeper.sh/version": "1.0.1",
+				"description":                    "The description",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Cleanup(viper.Reset)
+			viper.Set("library-annotations", tc.libraryAnnotations)
+
+			actual := getTemplateAnnotations(violations[0])
+			if diff := cmp.Diff(tc.expected, actual); diff != "" {
+				t.Errorf("unexpected annotations (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunCreateCommandCEL(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"pod-deny-privileged/src.rego": `package pod_deny_privileged
+
+violation[msg] {
+  input.review.object.spec.containers[_].securityContext.privileged
+  msg := "privileged"
+}
+`,
+		"pod-deny-privileged/src.cel.yaml": `validations:
+- expression: "!object.spec.containers.exists(c, c.securityContext.privileged)"
+  message: privileged
+`,
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), os.ModePerm); err != nil {
+			t.Fatalf("create dir: %s", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("write file: %s", err)
+		}
+	}
+
+	for _, version := range []string{"v1", "v1beta1"} {
+		t.Run(version, func(t *testing.T) {
+			t.Cleanup(viper.Reset)
+			output := t.TempDir()
+			viper.Set("output", output)
+			viper.Set("constraint-template-version", version)
+
+			if err := runCreateCommand(dir); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			content, err := os.ReadFile(filepath.Join(output, "template_PodDenyPrivileged.yaml"))
+			if err != nil {
+				t.Fatalf("read template: %s", err)
+			}
+
+			var template unstructured.Unstructured
+			if err := yaml.Unmarshal(content, &template.Object); err != nil {
+				t.Fatalf("unmarshal template: %s", err)
+			}
+			targets, _, _ := unstructured.NestedSlice(template.Object, "spec", "targets")
+			if len(targets) != 1 {
+				t.Fatalf("expected 1 target, got %d", len(targets))
+			}
+			target := targets[0].(map[string]any)
+
+			if rego, _ := target["rego"].(string); !strings.Contains(rego, "package pod_deny_privileged") {
+				t.Errorf("expected the Rego of the policy in the target, got %q", rego)
+			}
+
+			expected := []any{map[string]any{
+				"engine": "K8sNativeValidation",
+				"source": map[string]any{
+					"validations": []any{map[string]any{
+						"expression": "!object.spec.containers.exists(c, c.securityContext.privileged)",
+						"message":    "privileged",
+					}},
+				},
+			}}
+			if diff := cmp.Diff(expected, target["code"]); diff != "" {
+				t.Errorf("unexpected code (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunCreateCommandGenerateVAP(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"pod-deny-privileged/src.rego": `# METADATA
+# custom:
+#   generateVAP: true
+#   constraints:
+#   - name: enforced
+#   - name: skipped
+#     generateVAP: false
+package pod_deny_privileged
+
+violation[msg] {
+  msg := "privileged"
+}
+`,
+		"pod-deny-privileged/src.cel.yaml":   `validations: [{expression: "true"}]`,
+		"pod-deny-host-network/src.rego":     "package pod_deny_host_network\n\nviolation[msg] {\n  msg := \"host network\"\n}\n",
+		"pod-deny-host-network/src.cel.yaml": `validations: [{expression: "true"}]`,
+		"pod-deny-rego-only/src.rego":        "package pod_deny_rego_only\n\nviolation[msg] {\n  msg := \"rego\"\n}\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), os.ModePerm); err != nil {
+			t.Fatalf("create dir: %s", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("write file: %s", err)
+		}
+	}
+
+	read := func(t *testing.T, path string) *unstructured.Unstructured {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read file: %s", err)
+		}
+		var u unstructured.Unstructured
+		if err := yaml.Unmarshal(content, &u.Object); err != nil {
+			t.Fatalf("unmarshal file: %s", err)
+		}
+		return &u
+	}
+	templateGenerateVAP := func(t *testing.T, path string) any {
+		targets, _, _ := unstructured.NestedSlice(read(t, path).Object, "spec", "targets")
+		code, _, _ := unstructured.NestedSlice(targets[0].(map[string]any), "code")
+		if len(code) == 0 {
+			return nil
+		}
+		value, _, _ := unstructured.NestedFieldNoCopy(code[0].(map[string]any), "source", "generateVAP")
+		return value
+	}
+
+	t.Run("Annotation and flag", func(t *testing.T) {
+		t.Cleanup(viper.Reset)
+		output := t.TempDir()
+		viper.Set("output", output)
+		viper.Set("constraint-template-version", "v1")
+		viper.Set("generate-vap", true)
+
+		if err := runCreateCommand(dir); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		for name, expected := range map[string]any{
+			"template_PodDenyPrivileged.yaml":  true,
+			"template_PodDenyHostNetwork.yaml": true,
+			"template_PodDenyRegoOnly.yaml":    nil,
+		} {
+			if actual := templateGenerateVAP(t, filepath.Join(output, name)); actual != expected {
+				t.Errorf("unexpected generateVAP of %s. expected %v, actual %v", name, expected, actual)
+			}
+		}
+
+		for name, expected := range map[string]string{
+			"constraint_PodDenyPrivileged_enforced.yaml": "yes",
+			"constraint_PodDenyPrivileged_skipped.yaml":  "no",
+			"constraint_PodDenyHostNetwork.yaml":         "yes",
+			"constraint_PodDenyRegoOnly.yaml":            "",
+		} {
+			if actual := read(t, filepath.Join(output, name)).GetLabels()[useVAPLabel]; actual != expected {
+				t.Errorf("unexpected %s label of %s. expected %q, actual %q", useVAPLabel, name, expected, actual)
+			}
+		}
+	})
+
+	t.Run("ConstraintTemplate version", func(t *testing.T) {
+		t.Cleanup(viper.Reset)
+		viper.Set("output", t.TempDir())
+		viper.Set("constraint-template-version", "v1beta1")
+
+		err := runCreateCommand(dir)
+		if err == nil || !strings.Contains(err.Error(), "generateVAP requires ConstraintTemplate version v1") {
+			t.Errorf("expected error for the ConstraintTemplate version, got %v", err)
+		}
+	})
+}
+
+func TestRunCreateCommandOperations(t *testing.T) {
+	dir := t.TempDir()
+	policy := `# METADATA
+# custom:
+#   operations: [DELETE]
+package pod_deny_delete
+
+violation[msg] {
+  msg := "delete"
+}
+`
+	if err := os.MkdirAll(filepath.Join(dir, "pod-deny-delete"), os.ModePerm); err != nil {
+		t.Fatalf("create dir: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "pod-deny-delete", "src.rego"), []byte(policy), 0644); err != nil {
+		t.Fatalf("write file: %s", err)
+	}
+
+	for _, version := range []string{"v1", "v1beta1"} {
+		t.Run(version, func(t *testing.T) {
+			t.Cleanup(viper.Reset)
+			output := t.TempDir()
+			viper.Set("output", output)
+			viper.Set("constraint-template-version", version)
+
+			if err := runCreateCommand(dir); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			content, err := os.ReadFile(filepath.Join(output, "template_PodDenyDelete.yaml"))
+			if err != nil {
+				t.Fatalf("read file: %s", err)
+			}
+			var template unstructured.Unstructured
+			if err := yaml.Unmarshal(content, &template.Object); err != nil {
+				t.Fatalf("unmarshal file: %s", err)
+			}
+
+			targets, _, _ := unstructured.NestedSlice(template.Object, "spec", "targets")
+			operations, _, _ := unstructured.NestedStringSlice(targets[0].(map[string]any), "operations")
+			if diff := cmp.Diff([]string{"DELETE"}, operations); diff != "" {
+				t.Errorf("unexpected operations (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("Custom template without operations", func(t *testing.T) {
+		t.Cleanup(viper.Reset)
+		hook := log.NewGlobal()
+		t.Cleanup(func() { logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks)) })
+
+		customTemplate := filepath.Join(t.TempDir(), "template.tpl")
+		content := "apiVersion: templates.gatekeeper.sh/v1\nkind: ConstraintTemplate\nspec:\n  targets:\n  - target: admission.k8s.gatekeeper.sh\n"
+		if err := os.WriteFile(customTemplate, []byte(content), 0644); err != nil {
+			t.Fatalf("write file: %s", err)
+		}
+		viper.Set("output", t.TempDir())
+		viper.Set("constraint-template-custom-template-file", customTemplate)
+
+		if err := runCreateCommand(dir); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		var warned bool
+		for _, entry := range hook.AllEntries() {
+			if entry.Level == logrus.WarnLevel && strings.Contains(entry.Message, "does not set the operations") {
+				warned = true
+			}
+		}
+		if !warned {
+			t.Errorf("expected a warning for the operations the custom template does not set")
+		}
+	})
+}
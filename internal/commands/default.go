@@ -24,6 +24,12 @@ func NewDefaultCommand() *cobra.Command {
 
 	cmd.AddCommand(newCreateCommand())
 	cmd.AddCommand(newDocCommand())
+	cmd.AddCommand(newVerifyCommand())
+	cmd.AddCommand(newLintCommand())
+	cmd.AddCommand(newSchemaCommand())
+	cmd.AddCommand(newTestCommand())
+	cmd.AddCommand(newEvalCommand())
+	cmd.AddCommand(newAuditCommand())
 
 	return &cmd
 }
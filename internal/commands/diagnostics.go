@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/plexsystems/konstraint/internal/diagnostic"
+	"github.com/plexsystems/konstraint/internal/rego"
+)
+
+// addDiagnostics adds the diagnostics of err to the list. Any other error is
+// returned, as it is not caused by a single policy.
+func addDiagnostics(diagnostics *diagnostic.List, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var list diagnostic.List
+	if !errors.As(err, &list) {
+		return err
+	}
+
+	*diagnostics = append(*diagnostics, list...)
+	return nil
+}
+
+// reportDiagnostics writes the diagnostics in the given format, if any, and
+// returns an error when they contain errors. Without a format, the warnings
+// are logged and the errors are part of the returned error.
+func reportDiagnostics(diagnostics diagnostic.List, format string, out io.Writer) error {
+	diagnostics.Sort()
+
+	if format == "" {
+		var errs diagnostic.List
+		for _, d := range diagnostics {
+			if d.Severity == diagnostic.Error {
+				errs = append(errs, d)
+				continue
+			}
+			log.WithFields(log.Fields{
+				"code":     d.Code,
+				"location": d.Location(),
+			}).Warn(d.Message)
+		}
+		return errs.Err()
+	}
+
+	if err := diagnostic.Write(out, format, diagnostics); err != nil {
+		return fmt.Errorf("write diagnostics: %w", err)
+	}
+	if n := diagnostics.Errors(); n > 0 {
+		return fmt.Errorf("found %d error(s)", n)
+	}
+
+	return nil
+}
+
+// addUnusedParameters adds a warning for every declared parameter that the
+// policy never reads.
+func addUnusedParameters(diagnostics *diagnostic.List, policy rego.Rego) {
+	for _, name := range policy.UnusedParameters() {
+		diagnostics.Add(diagnostic.Warningf(diagnostic.CodeUnusedParameter, policy.ParameterLocation(name), "Parameter %q is declared but not used", name))
+	}
+}
+
+// addUndeterminedSyncData adds a warning when the policy reads resources from
+// data.inventory that cannot be determined, as Gatekeeper would not replicate
+// them without the requiresSyncData annotation.
+func addUndeterminedSyncData(diagnostics *diagnostic.List, policy rego.Rego) {
+	if location := policy.UndeterminedSyncData(); location != nil {
+		diagnostics.Add(diagnostic.Warningf(diagnostic.CodeUndeterminedSyncData, location, undeterminedSyncDataMessage))
+	}
+}
+
+const undeterminedSyncDataMessage = "The resources read from data.inventory cannot be determined, set them in custom.requiresSyncData"
+
+func validateDiagnosticsFormat(format string) error {
+	if format == "" {
+		return nil
+	}
+
+	for _, f := range diagnostic.Formats {
+		if f == format {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown diagnostics format %q, must be one of %s", format, strings.Join(diagnostic.Formats, ", "))
+}
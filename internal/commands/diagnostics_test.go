@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/plexsystems/konstraint/internal/diagnostic"
+)
+
+func TestReportDiagnostics(t *testing.T) {
+	warning := diagnostic.Diagnostic{Code: diagnostic.CodeUnusedParameter, Severity: diagnostic.Warning, Message: `Parameter "foo" is declared but not used`, File: "policy/src.rego", Line: 6, Column: 7}
+	failure := diagnostic.Diagnostic{Code: diagnostic.CodeRenderFailed, Severity: diagnostic.Error, Message: "render failed", File: "other/src.rego", Line: 1, Column: 1}
+
+	t.Run("Text", func(t *testing.T) {
+		hook := test.NewGlobal()
+		t.Cleanup(func() { log.StandardLogger().ReplaceHooks(make(log.LevelHooks)) })
+
+		var out bytes.Buffer
+		err := reportDiagnostics(diagnostic.List{warning, failure}, "", &out)
+		if err == nil || !strings.Contains(err.Error(), "render failed") {
+			t.Errorf("expected the error diagnostic in the error, got %v", err)
+		}
+		if err != nil && strings.Contains(err.Error(), warning.Message) {
+			t.Errorf("expected the warning to be logged instead of returned, got %v", err)
+		}
+		if out.Len() > 0 {
+			t.Errorf("expected nothing written without a format, got %q", out.String())
+		}
+
+		entries := hook.AllEntries()
+		if len(entries) != 1 {
+			t.Fatalf("expected one logged warning, got %d", len(entries))
+		}
+		if entries[0].Level != log.WarnLevel || entries[0].Message != warning.Message || entries[0].Data["location"] != "policy/src.rego:6:7" || entries[0].Data["code"] != warning.Code {
+			t.Errorf("unexpected logged warning: %s %s %v", entries[0].Level, entries[0].Message, entries[0].Data)
+		}
+	})
+
+	t.Run("Warnings only", func(t *testing.T) {
+		test.NewGlobal()
+		t.Cleanup(func() { log.StandardLogger().ReplaceHooks(make(log.LevelHooks)) })
+
+		if err := reportDiagnostics(diagnostic.List{warning}, "", &bytes.Buffer{}); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		hook := test.NewGlobal()
+		t.Cleanup(func() { log.StandardLogger().ReplaceHooks(make(log.LevelHooks)) })
+
+		var out bytes.Buffer
+		if err := reportDiagnostics(diagnostic.List{warning}, "json", &out); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if !strings.Contains(out.String(), diagnostic.CodeUnusedParameter) {
+			t.Errorf("expected the warning in the output, got %q", out.String())
+		}
+		if len(hook.AllEntries()) != 0 {
+			t.Errorf("expected no logged warnings with a format, got %d", len(hook.AllEntries()))
+		}
+	})
+}
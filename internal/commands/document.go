@@ -1,7 +1,9 @@
 package commands
 
 import (
+	"bytes"
 	_ "embed"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -12,6 +14,7 @@ import (
 	"unicode"
 
 	"github.com/go-sprout/sprout/sprigin"
+	"github.com/plexsystems/konstraint/internal/diagnostic"
 	"github.com/plexsystems/konstraint/internal/rego"
 
 	log "github.com/sirupsen/logrus"
@@ -29,6 +32,22 @@ type Header struct {
 	MatchLabels string
 	Anchor      string
 	Parameters  []rego.Parameter
+
+	// Additional matchers of the policy, if set.
+	Scope              string
+	Namespaces         []string
+	ExcludedNamespaces []string
+	NamespaceSelector  string
+	Name               string
+	Source             string
+
+	// Operations are the admission operations the policy is evaluated for,
+	// if set.
+	Operations []string
+
+	// EnforcementActions describe the effective enforcement action at each
+	// enforcement point when the policy uses scoped enforcement actions.
+	EnforcementActions []string
 }
 
 // Document is a single policy document.
@@ -76,7 +95,16 @@ Save the documentation to a specific directory
 	konstraint doc --output docs/policies.md
 
 Set the URL where the policies are hosted at
-	konstraint doc --url https://github.com/plexsystems/konstraint`,
+	konstraint doc --url https://github.com/plexsystems/konstraint
+
+Check that the documentation is up to date
+	konstraint doc --output docs/policies.md --check
+
+Annotate the problems with the policies in a GitHub Actions workflow
+	konstraint doc --diagnostics-format github
+
+Document the valid policies, and report the problems with the others
+	konstraint doc --keep-going`,
 
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := viper.BindPFlag("output", cmd.Flags().Lookup("output")); err != nil {
@@ -99,11 +127,31 @@ Set the URL where the policies are hosted at
 				return fmt.Errorf("bind include-comments flag: %w", err)
 			}
 
+			if err := viper.BindPFlag("check", cmd.Flags().Lookup("check")); err != nil {
+				return fmt.Errorf("bind check flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("diagnostics-format", cmd.Flags().Lookup("diagnostics-format")); err != nil {
+				return fmt.Errorf("bind diagnostics-format flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("keep-going", cmd.Flags().Lookup("keep-going")); err != nil {
+				return fmt.Errorf("bind keep-going flag: %w", err)
+			}
+
+			if err := validateDiagnosticsFormat(viper.GetString("diagnostics-format")); err != nil {
+				return err
+			}
+
 			path := "."
 			if len(args) > 0 {
 				path = args[0]
 			}
 
+			// A file that is out of date or a problem with a policy is not a
+			// usage error.
+			cmd.SilenceUsage = viper.GetBool("check") || viper.GetBool("keep-going") || viper.GetString("diagnostics-format") != ""
+
 			return runDocCommand(path)
 		},
 	}
@@ -113,6 +161,9 @@ Set the URL where the policies are hosted at
 	cmd.Flags().String("url", "", "The URL where the policy files are hosted at (e.g. https://github.com/policies)")
 	cmd.Flags().Bool("no-rego", false, "Do not include the Rego in the policy documentation")
 	cmd.Flags().Bool("include-comments", false, "Include comments from the rego source in the documentation")
+	cmd.Flags().Bool("check", false, "Check that the documentation on disk is up to date without writing it")
+	cmd.Flags().String("diagnostics-format", "", "Report all errors and warnings of the policies on stdout in this format. Options: json, sarif, github")
+	cmd.Flags().Bool("keep-going", false, "Generate the documentation of the valid policies even if other policies have errors")
 
 	return &cmd
 }
@@ -121,15 +172,20 @@ func runDocCommand(path string) error {
 	outputDirectory := filepath.Dir(viper.GetString("output"))
 	appliedTemplate := docTemplate
 
-	if err := os.MkdirAll(outputDirectory, os.ModePerm); err != nil {
-		return fmt.Errorf("create output dir: %w", err)
-	}
-
-	docs, err := getDocumentation(path, outputDirectory)
+	var diagnostics diagnostic.List
+	docs, err := getDocumentation(path, outputDirectory, &diagnostics)
 	if err != nil {
 		return fmt.Errorf("get documentation: %w", err)
 	}
 
+	// With keep-going, the documentation of the valid policies is still
+	// generated, and the problems are returned after that.
+	format := viper.GetString("diagnostics-format")
+	reportErr := reportDiagnostics(diagnostics, format, os.Stdout)
+	if reportErr != nil && !viper.GetBool("keep-going") {
+		return reportErr
+	}
+
 	if file := viper.GetString("template-file"); file != "" {
 		b, err := os.ReadFile(file)
 		if err != nil {
@@ -144,27 +200,47 @@ func runDocCommand(path string) error {
 		return fmt.Errorf("parsing template: %w", err)
 	}
 
-	f, err := os.OpenFile(viper.GetString("output"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
-	if err != nil {
-		return fmt.Errorf("opening file for writing: %w", err)
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, docs); err != nil {
+		return fmt.Errorf("executing template: %w", err)
 	}
 
-	if err := t.Execute(f, docs); err != nil {
-		return fmt.Errorf("executing template: %w", err)
+	files := []generatedFile{{path: viper.GetString("output"), content: buf.Bytes()}}
+	if viper.GetBool("check") {
+		// Keep the diagnostics on stdout parseable.
+		out := os.Stdout
+		if format != "" {
+			out = os.Stderr
+		}
+		if err := checkFiles(files, out); err != nil {
+			return errors.Join(reportErr, err)
+		}
+		return reportErr
+	}
+
+	if err := writeFiles(files); err != nil {
+		return fmt.Errorf("write files: %w", err)
 	}
 
 	var numPolicies int
 	for _, policies := range docs {
 		numPolicies += len(policies)
 	}
+	if reportErr != nil {
+		log.WithField("num_policies", numPolicies).Warn("generated the documentation of the valid policies")
+		return reportErr
+	}
 	log.WithField("num_policies", numPolicies).Info("completed successfully")
 
 	return nil
 }
 
-func getDocumentation(path string, outputDirectory string) (map[rego.Severity][]Document, error) {
+// getDocumentation returns the documents of the policies in path. Problems with
+// the policies are added to diagnostics, and the documents of the valid
+// policies are still returned.
+func getDocumentation(path string, outputDirectory string, diagnostics *diagnostic.List) (map[rego.Severity][]Document, error) {
 	policies, err := rego.GetAllSeveritiesWithoutImports(path)
-	if err != nil {
+	if err := addDiagnostics(diagnostics, err); err != nil {
 		return nil, fmt.Errorf("get all severities: %w", err)
 	}
 
@@ -174,13 +250,10 @@ func getDocumentation(path string, outputDirectory string) (map[rego.Severity][]
 
 	documents := make(map[rego.Severity][]Document)
 	for _, policy := range policies {
-		logger := log.WithFields(log.Fields{
-			"name": policy.Kind(),
-			"src":  policy.Path(),
-		})
+		addUnusedParameters(diagnostics, policy)
 
 		if policy.Title() == "" {
-			logger.Warn("No title set, skipping documentation generation.")
+			diagnostics.Add(diagnostic.Warningf(diagnostic.CodeMissingTitle, policy.Location(), "No title set, skipping documentation generation"))
 			continue
 		}
 
@@ -252,7 +325,7 @@ func getDocumentation(path string, outputDirectory string) (map[rego.Severity][]
 			}
 		}
 		if len(matchResources) == 0 {
-			logger.Warn("No kind matchers set, this can lead to poor policy performance.")
+			diagnostics.Add(diagnostic.Warningf(diagnostic.CodeMissingKindMatchers, policy.Location(), "No kind matchers set, this can lead to poor policy performance"))
 			matchResources = append(matchResources, "Any Resource")
 		}
 		for i := range matchResources {
@@ -265,6 +338,21 @@ func getDocumentation(path string, outputDirectory string) (map[rego.Severity][]
 		}
 		matchLabels = markdownReplacer.Replace(matchLabels)
 
+		var namespaceSelector string
+		if policy.AnnotationNamespaceSelectorMatcher() != nil {
+			namespaceSelector = labelSelectorDocString(policy.AnnotationNamespaceSelectorMatcher())
+		}
+		namespaceSelector = markdownReplacer.Replace(namespaceSelector)
+
+		namespaces := make([]string, len(policy.AnnotationNamespaceMatchers()))
+		for i, ns := range policy.AnnotationNamespaceMatchers() {
+			namespaces[i] = markdownReplacer.Replace(ns)
+		}
+		excludedNamespaces := make([]string, len(policy.AnnotationExcludedNamespaceMatchers()))
+		for i, ns := range policy.AnnotationExcludedNamespaceMatchers() {
+			excludedNamespaces[i] = markdownReplacer.Replace(ns)
+		}
+
 		parameters := annoParamsToLegacyFormat(policy.AnnotationParameters())
 		sort.Slice(parameters, func(i, j int) bool {
 			return parameters[i].Name < parameters[j].Name
@@ -273,13 +361,34 @@ func getDocumentation(path string, outputDirectory string) (map[rego.Severity][]
 			parameters[i].Name = markdownReplacer.Replace(parameters[i].Name)
 		}
 
+		operations := make([]string, len(policy.AnnotationOperations()))
+		for i, o := range policy.AnnotationOperations() {
+			operations[i] = markdownReplacer.Replace(o)
+		}
+
+		var enforcementActions []string
+		if policy.Enforcement() == "scoped" {
+			enforcementActions = enforcementActionsDocStrings(policy)
+		}
+		for i := range enforcementActions {
+			enforcementActions[i] = markdownReplacer.Replace(enforcementActions[i])
+		}
+
 		header := Header{
-			Title:       documentTitle,
-			Description: policy.Description(),
-			Resources:   matchResources,
-			MatchLabels: matchLabels,
-			Anchor:      anchor,
-			Parameters:  parameters,
+			Title:              documentTitle,
+			Description:        policy.Description(),
+			Resources:          matchResources,
+			MatchLabels:        matchLabels,
+			Anchor:             anchor,
+			Parameters:         parameters,
+			EnforcementActions: enforcementActions,
+			Scope:              markdownReplacer.Replace(policy.AnnotationScopeMatcher()),
+			Namespaces:         namespaces,
+			ExcludedNamespaces: excludedNamespaces,
+			NamespaceSelector:  namespaceSelector,
+			Name:               markdownReplacer.Replace(policy.AnnotationNameMatcher()),
+			Source:             policy.AnnotationSourceMatcher(),
+			Operations:         operations,
 		}
 
 		var rego string
@@ -323,6 +432,19 @@ func labelSelectorDocString(selector *metav1.LabelSelector) string {
 	return strings.TrimSuffix(result, ", ")
 }
 
+func enforcementActionsDocStrings(policy rego.Rego) []string {
+	var result []string
+	for _, point := range rego.EnforcementPoints {
+		actions := policy.EnforcementActionsAt(point)
+		if len(actions) == 0 {
+			actions = []string{"not enforced"}
+		}
+		result = append(result, fmt.Sprintf("%s: %s", point, strings.Join(actions, ", ")))
+	}
+
+	return result
+}
+
 func annoParamsToLegacyFormat(parameters map[string]apiextensionsv1.JSONSchemaProps) []rego.Parameter {
 	var results []rego.Parameter
 	for param, config := range parameters {
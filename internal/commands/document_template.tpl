@@ -23,6 +23,49 @@
 **MatchLabels:** {{ .Header.MatchLabels }}
 {{- end }}
 
+{{- if .Header.Scope }}
+
+**Scope:** {{ .Header.Scope }}
+{{- end }}
+
+{{- if .Header.Namespaces }}
+
+**Namespaces:** {{ join ", " .Header.Namespaces }}
+{{- end }}
+
+{{- if .Header.ExcludedNamespaces }}
+
+**Excluded Namespaces:** {{ join ", " .Header.ExcludedNamespaces }}
+{{- end }}
+
+{{- if .Header.NamespaceSelector }}
+
+**Namespace Selector:** {{ .Header.NamespaceSelector }}
+{{- end }}
+
+{{- if .Header.Name }}
+
+**Name:** {{ .Header.Name }}
+{{- end }}
+
+{{- if .Header.Source }}
+
+**Source:** {{ .Header.Source }}
+{{- end }}
+
+{{- if .Header.Operations }}
+
+**Operations:** {{ join ", " .Header.Operations }}
+{{- end }}
+
+{{- if .Header.EnforcementActions }}
+
+**Enforcement Actions:**
+{{ range .Header.EnforcementActions }}
+* {{ . }}
+{{- end }}
+{{- end }}
+
 {{- if .Header.Parameters }}
 
 **Parameters:**
@@ -0,0 +1,503 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/plexsystems/konstraint/internal/diagnostic"
+	"github.com/plexsystems/konstraint/internal/gatekeeper"
+	"github.com/plexsystems/konstraint/internal/rego"
+
+	"github.com/open-policy-agent/opa/ast"
+	oparego "github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/topdown"
+	"github.com/open-policy-agent/opa/topdown/lineage"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// explainModes are the modes of the trace printed by the eval command, which
+// are the same as the ones of opa eval.
+var explainModes = map[string]func([]*topdown.Event) []*topdown.Event{
+	"notes": lineage.Notes,
+	"fails": lineage.Fails,
+	"full":  lineage.Full,
+}
+
+func newEvalCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "eval <policy-dir>",
+		Short: "Evaluate a policy against the resources in a manifest",
+		Long: `Eval reviews the resources in a manifest with a single policy, the same way
+Gatekeeper does when they are created. The matchers of the policy are applied first,
+and every resource that matches is passed to the policy in input.review, along with
+the parameters of the Constraint in input.parameters. The messages of the violations
+are printed for every resource.
+
+The policy is loaded from the parent directory of the policy directory, so that the
+libraries it imports can be found. Use --root when the libraries are elsewhere.
+
+Policies that read data.inventory are given the resources of the manifests set with
+--inventory, in the same layout as the resources that Gatekeeper syncs from the cluster.`,
+		Example: `Evaluate a policy against a Deployment
+	konstraint eval examples/container_deny_privileged -f deployment.yaml
+
+Evaluate a policy with the parameters or the match of a Constraint
+	konstraint eval examples/required_labels -f deployment.yaml --parameters constraint.yaml
+
+Explain why a policy was or was not violated
+	konstraint eval examples/container_deny_privileged -f deployment.yaml --explain notes`,
+
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := viper.BindPFlag("file", cmd.Flags().Lookup("file")); err != nil {
+				return fmt.Errorf("bind file flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("parameters", cmd.Flags().Lookup("parameters")); err != nil {
+				return fmt.Errorf("bind parameters flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("constraint", cmd.Flags().Lookup("constraint")); err != nil {
+				return fmt.Errorf("bind constraint flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("data", cmd.Flags().Lookup("data")); err != nil {
+				return fmt.Errorf("bind data flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("inventory", cmd.Flags().Lookup("inventory")); err != nil {
+				return fmt.Errorf("bind inventory flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("explain", cmd.Flags().Lookup("explain")); err != nil {
+				return fmt.Errorf("bind explain flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("root", cmd.Flags().Lookup("root")); err != nil {
+				return fmt.Errorf("bind root flag: %w", err)
+			}
+
+			files := viper.GetStringSlice("file")
+			if len(files) == 0 {
+				return fmt.Errorf("no manifest set, use --file")
+			}
+
+			explain := viper.GetString("explain")
+			if _, ok := explainModes[explain]; explain != "" && !ok {
+				return fmt.Errorf("unknown explain mode %q, expected notes, fails or full", explain)
+			}
+
+			options := evalOptions{
+				files:      files,
+				parameters: viper.GetString("parameters"),
+				constraint: viper.GetString("constraint"),
+				data:       viper.GetStringSlice("data"),
+				inventory:  viper.GetStringSlice("inventory"),
+				explain:    explain,
+				root:       viper.GetString("root"),
+			}
+
+			// A violation is not a usage error.
+			cmd.SilenceUsage = true
+
+			return runEvalCommand(args[0], options, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringSliceP("file", "f", nil, "Manifests with the resources to evaluate")
+	cmd.Flags().String("parameters", "", "File with the parameters, or with a Constraint whose parameters and match are used")
+	cmd.Flags().String("constraint", "", "Name of the Constraint of the policy whose parameters and matchers are used")
+	cmd.Flags().StringSlice("data", nil, "Files or directories with JSON or YAML data to load, such as data.inventory")
+	cmd.Flags().StringSlice("inventory", nil, "Manifests, or directories with manifests, with the resources to sync into data.inventory")
+	cmd.Flags().String("explain", "", "Print a trace of the evaluation (notes, fails or full)")
+	cmd.Flags().String("root", "", "Directory with the policy and its libraries, defaults to the parent of the policy directory")
+
+	return &cmd
+}
+
+type evalOptions struct {
+	files      []string
+	parameters string
+	constraint string
+	data       []string
+	inventory  []string
+	explain    string
+	root       string
+}
+
+func runEvalCommand(path string, options evalOptions, out io.Writer) error {
+	ctx := context.Background()
+
+	policyDir := path
+	if info, err := os.Stat(path); err != nil {
+		return fmt.Errorf("stat policy: %w", err)
+	} else if !info.IsDir() {
+		policyDir = filepath.Dir(path)
+	}
+
+	root := options.root
+	if root == "" {
+		root = filepath.Dir(policyDir)
+	}
+
+	violation, err := getPolicy(root, policyDir)
+	if err != nil {
+		return err
+	}
+
+	logger := log.WithFields(log.Fields{
+		"name": violation.Kind(),
+		"src":  violation.Path(),
+	})
+
+	if options.constraint != "" {
+		var found bool
+		for _, c := range violation.AnnotationConstraints() {
+			if c.Name != options.constraint {
+				continue
+			}
+
+			violation, err = violation.ForConstraint(c)
+			if err != nil {
+				return fmt.Errorf("get constraint %s: %w", c.Name, err)
+			}
+			found = true
+			break
+		}
+		if !found {
+			return fmt.Errorf("constraint %q not found in the metadata of %s", options.constraint, violation.Path())
+		}
+	}
+
+	match := violation.AnnotationMatchers()
+	parameters := violation.ConstraintParameters()
+	if options.parameters != "" {
+		parameters, match, err = readParameters(options.parameters, match)
+		if err != nil {
+			return fmt.Errorf("read parameters: %w", err)
+		}
+	}
+
+	query := violation.PackagePath() + ".violation"
+	compiler, err := compilePolicy(root, policyDir)
+	if err != nil {
+		return fmt.Errorf("compile policy: %w", err)
+	}
+
+	store, err := loadData(options.data)
+	if err != nil {
+		return fmt.Errorf("load data: %w", err)
+	}
+
+	resources, err := readManifests(options.files)
+	if err != nil {
+		return fmt.Errorf("read manifests: %w", err)
+	}
+	if len(resources) == 0 {
+		return fmt.Errorf("no resources found in %s", strings.Join(options.files, ", "))
+	}
+
+	inventory, err := readManifests(options.inventory)
+	if err != nil {
+		return fmt.Errorf("read inventory: %w", err)
+	}
+	if len(inventory) > 0 {
+		var objects []*unstructured.Unstructured
+		for _, resource := range inventory {
+			objects = append(objects, resource.object)
+		}
+		data, err := gatekeeper.Inventory(objects)
+		if err != nil {
+			return fmt.Errorf("build inventory: %w", err)
+		}
+		if err := storage.WriteOne(ctx, store, storage.AddOp, storage.Path{"inventory"}, data); err != nil {
+			return fmt.Errorf("write inventory: %w", err)
+		}
+	}
+
+	// Namespaces in the manifests and the inventory are used to evaluate
+	// namespaceSelectors.
+	namespaces := getNamespaces(append(inventory, resources...))
+
+	var violated int
+	for _, resource := range resources {
+		object := resource.review(namespaces)
+		if !gatekeeper.HandlesOperation(violation.AnnotationOperations(), object.Operation()) {
+			fmt.Fprintf(out, "SKIP %s: the %s operation is not one of the operations of %s\n", resource, object.Operation(), violation.Kind())
+			continue
+		}
+
+		matched, err := gatekeeper.Matches(match, object)
+		if err != nil {
+			return fmt.Errorf("match %s: %w", resource, err)
+		}
+		if !matched {
+			fmt.Fprintf(out, "SKIP %s: not matched by the matchers of %s\n", resource, violation.Kind())
+			continue
+		}
+
+		input, err := gatekeeper.ReviewInput(object, parameters)
+		if err != nil {
+			return fmt.Errorf("review %s: %w", resource, err)
+		}
+
+		var tracer *topdown.BufferTracer
+		evalOptions := []func(*oparego.Rego){
+			oparego.Query(query),
+			oparego.Compiler(compiler),
+			oparego.Store(store),
+			oparego.Input(input),
+			oparego.EnablePrintStatements(true),
+			oparego.PrintHook(topdown.NewPrintHook(out)),
+		}
+		if options.explain != "" {
+			tracer = topdown.NewBufferTracer()
+			evalOptions = append(evalOptions, oparego.QueryTracer(tracer))
+		}
+
+		results, err := oparego.New(evalOptions...).Eval(ctx)
+		if err != nil {
+			return fmt.Errorf("evaluate %s: %w", resource, err)
+		}
+
+		messages, err := violationMessages(results)
+		if err != nil {
+			return fmt.Errorf("evaluate %s: %w", resource, err)
+		}
+		logger.WithField("resource", resource.String()).Debugf("Found %d violation(s)", len(messages))
+
+		if len(messages) == 0 {
+			fmt.Fprintf(out, "PASS %s\n", resource)
+		} else {
+			violated++
+			fmt.Fprintf(out, "FAIL %s\n", resource)
+			for _, message := range messages {
+				fmt.Fprintf(out, "  %s\n", message)
+			}
+		}
+
+		if tracer != nil {
+			fmt.Fprintln(out)
+			topdown.PrettyTraceWithLocation(out, explainModes[options.explain](*tracer))
+			fmt.Fprintln(out)
+		}
+	}
+
+	if violated > 0 {
+		return fmt.Errorf("%d of %d resources violate %s", violated, len(resources), violation.Kind())
+	}
+
+	return nil
+}
+
+// getPolicy returns the policy in the directory, loaded from the root
+// directory along with its libraries. Problems with the other policies under
+// the root are ignored, only those in the directory are returned.
+func getPolicy(root string, policyDir string) (rego.Rego, error) {
+	policyDir, err := filepath.Abs(policyDir)
+	if err != nil {
+		return rego.Rego{}, fmt.Errorf("get absolute path: %w", err)
+	}
+
+	violations, err := rego.GetViolations(root)
+	var diagnostics diagnostic.List
+	if err := addDiagnostics(&diagnostics, err); err != nil {
+		return rego.Rego{}, fmt.Errorf("get violations: %w", err)
+	}
+
+	var policyDiagnostics diagnostic.List
+	for _, d := range diagnostics {
+		dir, err := filepath.Abs(filepath.Dir(d.File))
+		if err != nil {
+			return rego.Rego{}, fmt.Errorf("get absolute path: %w", err)
+		}
+		if d.File != "" && dir == policyDir {
+			policyDiagnostics = append(policyDiagnostics, d)
+		}
+	}
+	if err := policyDiagnostics.Err(); err != nil {
+		return rego.Rego{}, fmt.Errorf("get violations: %w", err)
+	}
+
+	for _, violation := range violations {
+		dir, err := filepath.Abs(filepath.Dir(violation.Path()))
+		if err != nil {
+			return rego.Rego{}, fmt.Errorf("get absolute path: %w", err)
+		}
+		if dir == policyDir {
+			return violation, nil
+		}
+	}
+
+	// The policy may be missing because of a problem that is not located in
+	// its directory, such as a library that cannot be parsed.
+	if err := diagnostics.Err(); err != nil {
+		return rego.Rego{}, fmt.Errorf("no policy with a violation rule found in %s: %w", policyDir, err)
+	}
+
+	return rego.Rego{}, fmt.Errorf("no policy with a violation rule found in %s", policyDir)
+}
+
+// compilePolicy compiles the modules of the policy in the directory, and the
+// modules of the libraries that they import.
+func compilePolicy(root string, policyDir string) (*ast.Compiler, error) {
+	policyDir, err := filepath.Abs(policyDir)
+	if err != nil {
+		return nil, fmt.Errorf("get absolute path: %w", err)
+	}
+
+	// Files that cannot be parsed are left out. They are reported by
+	// getPolicy when they belong to the policy, and otherwise only fail the
+	// compilation when the policy imports them.
+	modules, err := rego.LoadModules(root)
+	var parseErrors diagnostic.List
+	if err != nil && !errors.As(err, &parseErrors) {
+		return nil, fmt.Errorf("load modules: %w", err)
+	}
+
+	packages := make(map[string][]string)
+	var queue []string
+	for name, module := range modules {
+		if strings.HasSuffix(name, "_test.rego") {
+			continue
+		}
+
+		pkg := module.Package.Path.String()
+		packages[pkg] = append(packages[pkg], name)
+
+		dir, err := filepath.Abs(filepath.Dir(name))
+		if err != nil {
+			return nil, fmt.Errorf("get absolute path: %w", err)
+		}
+		if dir == policyDir {
+			queue = append(queue, pkg)
+		}
+	}
+
+	// The imports are followed from the policy to the libraries. An import
+	// may refer to a rule rather than to a package.
+	selected := make(map[string]*ast.Module)
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+
+		for _, name := range packages[pkg] {
+			if selected[name] != nil {
+				continue
+			}
+			selected[name] = modules[name]
+
+			for _, imp := range modules[name].Imports {
+				// Imports of input, future keywords or rego.v1 are not
+				// packages.
+				path := imp.Path.String()
+				if !strings.HasPrefix(path, "data.") {
+					continue
+				}
+				if _, ok := packages[path]; !ok {
+					if i := strings.LastIndex(path, "."); i >= 0 {
+						path = path[:i]
+					}
+				}
+				queue = append(queue, path)
+			}
+		}
+	}
+
+	compiler := ast.NewCompiler().WithEnablePrintStatements(true)
+	if compiler.Compile(selected); compiler.Failed() {
+		return nil, fmt.Errorf("compile: %w", compiler.Errors)
+	}
+
+	return compiler, nil
+}
+
+// readParameters reads the parameters from a file. When the file contains a
+// Constraint, the parameters and the match of its spec are returned instead,
+// and the match falls back to the given match.
+func readParameters(path string, match rego.AnnoMatch) (map[string]any, rego.AnnoMatch, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, match, fmt.Errorf("read file: %w", err)
+	}
+
+	var parameters map[string]any
+	if err := yaml.Unmarshal(content, &parameters); err != nil {
+		return nil, match, fmt.Errorf("unmarshal %s: %w", path, err)
+	}
+
+	constraint := unstructured.Unstructured{Object: parameters}
+	if constraint.GetKind() == "" {
+		return parameters, match, nil
+	}
+
+	parameters, _, err = unstructured.NestedMap(constraint.Object, "spec", "parameters")
+	if err != nil {
+		return nil, match, fmt.Errorf("get parameters: %w", err)
+	}
+
+	m, ok, err := unstructured.NestedMap(constraint.Object, "spec", "match")
+	if err != nil {
+		return nil, match, fmt.Errorf("get match: %w", err)
+	}
+	if ok {
+		match = rego.AnnoMatch{}
+		b, err := json.Marshal(m)
+		if err != nil {
+			return nil, match, fmt.Errorf("marshal match: %w", err)
+		}
+		if err := json.Unmarshal(b, &match); err != nil {
+			return nil, match, fmt.Errorf("unmarshal match: %w", err)
+		}
+	}
+
+	return parameters, match, nil
+}
+
+// violationMessages returns the sorted messages of the violations, which are
+// either objects with a msg field, as Gatekeeper expects, or strings.
+func violationMessages(results oparego.ResultSet) ([]string, error) {
+	var messages []string
+	for _, result := range results {
+		for _, expression := range result.Expressions {
+			violations, ok := expression.Value.([]any)
+			if !ok {
+				return nil, fmt.Errorf("unexpected violations %v", expression.Value)
+			}
+
+			for _, violation := range violations {
+				if msg, ok := violation.(string); ok {
+					messages = append(messages, msg)
+					continue
+				}
+				if v, ok := violation.(map[string]any); ok {
+					if msg, ok := v["msg"].(string); ok {
+						messages = append(messages, msg)
+						continue
+					}
+				}
+
+				b, err := json.Marshal(violation)
+				if err != nil {
+					return nil, fmt.Errorf("marshal violation: %w", err)
+				}
+				messages = append(messages, string(b))
+			}
+		}
+	}
+	sort.Strings(messages)
+
+	return messages, nil
+}
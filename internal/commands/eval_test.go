@@ -0,0 +1,374 @@
+package commands
+
+import (
+	"bytes"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunEvalCommand(t *testing.T) {
+	files := map[string]string{
+		"lib/core.rego": `package lib.core
+
+import future.keywords.if
+
+labels := input.review.object.metadata.labels
+
+format(msg) := {"msg": msg}
+`,
+		"required_labels/src.rego": `# METADATA
+# title: Required labels
+# custom:
+#   matchers:
+#     kinds:
+#     - apiGroups: ["apps"]
+#       kinds: ["Deployment"]
+#   parameters:
+#     labels:
+#       type: array
+#       items:
+#         type: string
+#   constraints:
+#   - name: required-team
+#     parameters:
+#       labels: ["team"]
+package required_labels
+
+import data.lib.core
+import future.keywords.contains
+import future.keywords.if
+import future.keywords.in
+
+violation contains core.format(msg) if {
+	some label in input.parameters.labels
+	not core.labels[label]
+	msg := sprintf("missing label %s", [label])
+}
+`,
+		"manifest.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: frontend
+  namespace: default
+  labels:
+    app: frontend
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: frontend
+  namespace: default
+`,
+		"parameters.yaml": `labels: ["app", "owner", "tier"]`,
+		"constraint.yaml": `apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: RequiredLabels
+metadata:
+  name: required-owner
+spec:
+  match:
+    kinds:
+    - apiGroups: [""]
+      kinds: ["Pod"]
+  parameters:
+    labels: ["owner"]
+`,
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifest := filepath.Join(dir, "manifest.yaml")
+
+	testCases := []struct {
+		desc     string
+		options  evalOptions
+		expected []string
+		wantErr  bool
+	}{
+		{
+			desc:    "Without parameters",
+			options: evalOptions{},
+			expected: []string{
+				"PASS " + manifest + ": Deployment default/frontend",
+				"SKIP " + manifest + ": Pod default/frontend: not matched by the matchers of RequiredLabels",
+			},
+		},
+		{
+			desc:    "Parameters file",
+			options: evalOptions{parameters: filepath.Join(dir, "parameters.yaml")},
+			expected: []string{
+				"FAIL " + manifest + ": Deployment default/frontend",
+				"  missing label owner",
+				"  missing label tier",
+				"SKIP " + manifest + ": Pod default/frontend: not matched by the matchers of RequiredLabels",
+			},
+			wantErr: true,
+		},
+		{
+			desc:    "Constraint of the policy",
+			options: evalOptions{constraint: "required-team"},
+			expected: []string{
+				"FAIL " + manifest + ": Deployment default/frontend",
+				"  missing label team",
+				"SKIP " + manifest + ": Pod default/frontend: not matched by the matchers of RequiredLabels",
+			},
+			wantErr: true,
+		},
+		{
+			desc:    "Constraint file",
+			options: evalOptions{parameters: filepath.Join(dir, "constraint.yaml")},
+			expected: []string{
+				"SKIP " + manifest + ": Deployment default/frontend: not matched by the matchers of RequiredLabels",
+				"FAIL " + manifest + ": Pod default/frontend",
+				"  missing label owner",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			tc.options.files = []string{manifest}
+
+			var out bytes.Buffer
+			err := runEvalCommand(filepath.Join(dir, "required_labels"), tc.options, &out)
+			if tc.wantErr && err == nil {
+				t.Errorf("expected error, got none")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+
+			actual := strings.TrimSpace(out.String())
+			expected := strings.Join(tc.expected, "\n")
+			if actual != expected {
+				t.Errorf("unexpected output:\n%s\nwant:\n%s", actual, expected)
+			}
+		})
+	}
+}
+
+func TestRunEvalCommandOperations(t *testing.T) {
+	files := map[string]string{
+		"immutable_team/src.rego": `# METADATA
+# title: Immutable team
+# custom:
+#   operations: [UPDATE]
+package immutable_team
+
+violation[{"msg": msg}] {
+	input.review.object.metadata.labels.team != input.review.oldObject.metadata.labels.team
+	msg := sprintf("%s cannot change the team", [input.review.userInfo.username])
+}
+`,
+		"manifest.yaml": `apiVersion: admission.k8s.io/v1
+kind: AdmissionReview
+request:
+  operation: UPDATE
+  userInfo:
+    username: alice
+  object:
+    apiVersion: v1
+    kind: Pod
+    metadata:
+      name: frontend
+      namespace: default
+      labels:
+        team: payments
+  oldObject:
+    apiVersion: v1
+    kind: Pod
+    metadata:
+      name: frontend
+      namespace: default
+      labels:
+        team: identity
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: backend
+  namespace: default
+`,
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifest := filepath.Join(dir, "manifest.yaml")
+
+	var out bytes.Buffer
+	if err := runEvalCommand(filepath.Join(dir, "immutable_team"), evalOptions{files: []string{manifest}}, &out); err == nil {
+		t.Errorf("expected error, got none")
+	}
+
+	actual := strings.TrimSpace(out.String())
+	expected := strings.Join([]string{
+		"FAIL " + manifest + ": UPDATE Pod default/frontend",
+		"  alice cannot change the team",
+		"SKIP " + manifest + ": Pod default/backend: the CREATE operation is not one of the operations of ImmutableTeam",
+	}, "\n")
+	if actual != expected {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", actual, expected)
+	}
+}
+
+func TestRunEvalCommandHelperPackage(t *testing.T) {
+	files := map[string]string{
+		"host_network/src.rego": `# METADATA
+# title: Host network
+package host_network
+
+import data.host_network.helpers
+
+violation[{"msg": msg}] {
+	helpers.uses_host_network
+	msg := "uses the host network"
+}
+`,
+		"host_network/helpers.rego": `package host_network.helpers
+
+uses_host_network {
+	input.review.object.spec.hostNetwork
+}
+`,
+		"manifest.yaml": `apiVersion: v1
+kind: Pod
+metadata:
+  name: host
+spec:
+  hostNetwork: true
+`,
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The helper package is in the same directory, but the query is the
+	// violation rule of the policy.
+	manifest := filepath.Join(dir, "manifest.yaml")
+	for range 10 {
+		var out bytes.Buffer
+		if err := runEvalCommand(filepath.Join(dir, "host_network"), evalOptions{files: []string{manifest}}, &out); err == nil {
+			t.Fatalf("expected error, got none\n%s", out.String())
+		}
+		if !strings.Contains(out.String(), "uses the host network") {
+			t.Fatalf("expected the violation of the policy, got %q", out.String())
+		}
+	}
+}
+
+func TestRunEvalCommandBrokenPolicies(t *testing.T) {
+	const importInput = `package import_input
+
+import input
+import future.keywords.contains
+import future.keywords.if
+
+violation contains {"msg": "no labels"} if {
+	not input.review.object.metadata.labels
+}
+`
+	const manifest = `apiVersion: v1
+kind: Pod
+metadata:
+  name: frontend
+  namespace: default
+`
+	broken := map[string]string{
+		"parse_error/src.rego": `package parse_error
+
+violation[msg] {
+`,
+		"compile_error/src.rego": `package compile_error
+
+violation[msg] {
+	msg := undefined_function(input)
+}
+`,
+	}
+
+	writeFiles := func(t *testing.T, files map[string]string) string {
+		dir := t.TempDir()
+		for name, content := range files {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dir
+	}
+
+	for _, tc := range []struct {
+		desc   string
+		broken bool
+	}{
+		{desc: "Import of input"},
+		{desc: "Next to broken policies", broken: true},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			files := map[string]string{
+				"import_input/src.rego": importInput,
+				"manifest.yaml":         manifest,
+			}
+			if tc.broken {
+				maps.Copy(files, broken)
+			}
+			dir := writeFiles(t, files)
+
+			var out bytes.Buffer
+			options := evalOptions{files: []string{filepath.Join(dir, "manifest.yaml")}}
+			if err := runEvalCommand(filepath.Join(dir, "import_input"), options, &out); err == nil {
+				t.Errorf("expected error for the violation, got none")
+			}
+
+			expected := "FAIL " + filepath.Join(dir, "manifest.yaml") + ": Pod default/frontend\n  no labels"
+			if actual := strings.TrimSpace(out.String()); actual != expected {
+				t.Errorf("unexpected output:\n%s\nwant:\n%s", actual, expected)
+			}
+		})
+	}
+
+	for policy := range broken {
+		policy = filepath.Dir(policy)
+		t.Run("Broken policy "+policy, func(t *testing.T) {
+			dir := writeFiles(t, broken)
+
+			options := evalOptions{files: []string{filepath.Join(dir, "manifest.yaml")}}
+			err := runEvalCommand(filepath.Join(dir, policy), options, &bytes.Buffer{})
+			if err == nil || !strings.Contains(err.Error(), filepath.Join(policy, "src.rego")) {
+				t.Errorf("expected error for %s, got %v", policy, err)
+			}
+		})
+	}
+}
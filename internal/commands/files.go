@@ -0,0 +1,165 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	log "github.com/sirupsen/logrus"
+)
+
+// manifestFileName is the name of the file that lists the files Konstraint
+// generated into an output directory.
+const manifestFileName = ".konstraint-manifest"
+
+// generatedFile is a file rendered by Konstraint that has not yet been
+// written to disk.
+type generatedFile struct {
+	path    string
+	content []byte
+
+	// remove is set when the file is no longer generated and should be
+	// removed from disk.
+	remove bool
+}
+
+func writeFiles(files []generatedFile) error {
+	for _, file := range files {
+		if file.remove {
+			if err := os.Remove(file.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("removing %s: %w", file.path, err)
+			}
+			log.WithField("path", file.path).Info("Removed orphaned file")
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(file.path), os.ModePerm); err != nil {
+			return fmt.Errorf("create output dir: %w", err)
+		}
+
+		if err := os.WriteFile(file.path, file.content, 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", file.path, err)
+		}
+	}
+
+	return nil
+}
+
+// checkFiles compares the rendered files with the files on disk without
+// writing anything. A unified diff is written to out for every file that is
+// missing or out of date.
+func checkFiles(files []generatedFile, out io.Writer) error {
+	var outdated int
+	for _, file := range files {
+		fromFile, toFile := file.path, file.path
+		if file.remove {
+			toFile = "/dev/null"
+		}
+
+		current, err := os.ReadFile(file.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			if file.remove {
+				continue
+			}
+			fromFile = "/dev/null"
+		} else if err != nil {
+			return fmt.Errorf("reading %s: %w", file.path, err)
+		} else if bytes.Equal(current, file.content) {
+			continue
+		}
+
+		outdated++
+		log.WithField("path", file.path).Error("Generated file is out of date")
+
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        splitLines(current),
+			B:        splitLines(file.content),
+			FromFile: fromFile,
+			ToFile:   toFile,
+			Context:  3,
+		})
+		if err != nil {
+			return fmt.Errorf("diff %s: %w", file.path, err)
+		}
+		if _, err := fmt.Fprint(out, diff); err != nil {
+			return fmt.Errorf("write diff: %w", err)
+		}
+	}
+
+	if outdated > 0 {
+		return fmt.Errorf("%d of %d generated files are out of date", outdated, len(files))
+	}
+
+	log.WithField("num_files", len(files)).Info("generated files are up to date")
+	return nil
+}
+
+// splitLines splits the content into lines that keep their line endings,
+// as expected by difflib.
+func splitLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// updateManifest returns the manifest of the files generated into the output
+// directory, along with the files listed in the previous manifest that are no
+// longer generated. When prune is set, those files are marked for removal.
+// Otherwise they are kept in the manifest so that a later run can prune them.
+func updateManifest(outputDir string, files []generatedFile, prune bool) ([]generatedFile, error) {
+	manifestPath := filepath.Join(outputDir, manifestFileName)
+
+	generated := make(map[string]bool, len(files))
+	for _, file := range files {
+		generated[filepath.Base(file.path)] = true
+	}
+
+	previous, err := os.ReadFile(manifestPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+
+	var orphaned []generatedFile
+	for _, line := range strings.Split(string(previous), "\n") {
+		name := strings.TrimSpace(line)
+		if name == "" || strings.HasPrefix(name, "#") || generated[name] {
+			continue
+		}
+
+		// Never touch files outside of the output directory, even if the
+		// manifest was edited by hand.
+		if filepath.Base(name) != name {
+			return nil, fmt.Errorf("invalid file name in manifest %s: %s", manifestPath, name)
+		}
+
+		path := filepath.Join(outputDir, name)
+		if prune {
+			orphaned = append(orphaned, generatedFile{path: path, remove: true})
+			continue
+		}
+
+		if _, err := os.Stat(path); err == nil {
+			log.WithField("path", path).Warn("File is no longer generated, use --prune to remove it")
+			generated[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(generated))
+	for name := range generated {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	manifest := "# Files generated by konstraint. Do not edit.\n" + strings.Join(names, "\n") + "\n"
+	return append(orphaned, generatedFile{path: manifestPath, content: []byte(manifest)}), nil
+}
@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCheckFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "current.yaml"), []byte("foo: bar\n"), 0644); err != nil {
+		t.Fatalf("write file: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "stale.yaml"), []byte("foo: bar\n"), 0644); err != nil {
+		t.Fatalf("write file: %s", err)
+	}
+
+	current := generatedFile{path: filepath.Join(dir, "current.yaml"), content: []byte("foo: bar\n")}
+	stale := generatedFile{path: filepath.Join(dir, "stale.yaml"), content: []byte("foo: baz\n")}
+	missing := generatedFile{path: filepath.Join(dir, "missing.yaml"), content: []byte("foo: bar\n")}
+
+	var out bytes.Buffer
+	if err := checkFiles([]generatedFile{current}, &out); err != nil {
+		t.Errorf("unexpected error for up to date file: %s", err)
+	}
+	if out.Len() > 0 {
+		t.Errorf("unexpected diff for up to date file:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := checkFiles([]generatedFile{current, stale, missing}, &out); err == nil {
+		t.Errorf("expected error for out of date files")
+	}
+
+	expected := "--- " + stale.path + "\n+++ " + stale.path + "\n@@ -1 +1 @@\n-foo: bar\n+foo: baz\n" +
+		"--- /dev/null\n+++ " + missing.path + "\n@@ -0,0 +1 @@\n+foo: bar\n"
+	if out.String() != expected {
+		t.Errorf("unexpected diff. expected\n%s\nactual\n%s", expected, out.String())
+	}
+
+	if _, err := os.Stat(missing.path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing file was written: %v", err)
+	}
+}
+
+func TestUpdateManifest(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"template_Kept.yaml", "template_Removed.yaml", "handwritten.yaml"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("foo: bar\n"), 0644); err != nil {
+			t.Fatalf("write file: %s", err)
+		}
+	}
+	manifest := "# Files generated by konstraint. Do not edit.\ntemplate_Kept.yaml\ntemplate_Removed.yaml\n"
+	if err := os.WriteFile(filepath.Join(dir, manifestFileName), []byte(manifest), 0644); err != nil {
+		t.Fatalf("write manifest: %s", err)
+	}
+
+	files := []generatedFile{
+		{path: filepath.Join(dir, "template_Kept.yaml")},
+		{path: filepath.Join(dir, "template_Added.yaml")},
+	}
+
+	actual, err := updateManifest(dir, files, false)
+	if err != nil {
+		t.Fatalf("update manifest: %s", err)
+	}
+	expected := []generatedFile{
+		{path: filepath.Join(dir, manifestFileName), content: []byte("# Files generated by konstraint. Do not edit.\ntemplate_Added.yaml\ntemplate_Kept.yaml\ntemplate_Removed.yaml\n")},
+	}
+	if diff := cmp.Diff(expected, actual, cmp.AllowUnexported(generatedFile{})); diff != "" {
+		t.Errorf("unexpected manifest without prune:\n %v", diff)
+	}
+
+	actual, err = updateManifest(dir, files, true)
+	if err != nil {
+		t.Fatalf("update manifest: %s", err)
+	}
+	expected = []generatedFile{
+		{path: filepath.Join(dir, "template_Removed.yaml"), remove: true},
+		{path: filepath.Join(dir, manifestFileName), content: []byte("# Files generated by konstraint. Do not edit.\ntemplate_Added.yaml\ntemplate_Kept.yaml\n")},
+	}
+	if diff := cmp.Diff(expected, actual, cmp.AllowUnexported(generatedFile{})); diff != "" {
+		t.Errorf("unexpected manifest with prune:\n %v", diff)
+	}
+}
@@ -0,0 +1,341 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/plexsystems/konstraint/internal/diagnostic"
+	"github.com/plexsystems/konstraint/internal/rego"
+
+	"github.com/open-policy-agent/opa/ast"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// lintFinding is a problem that a lint rule found in a policy. It is
+// reported as a diagnostic with the name and severity of the rule.
+type lintFinding struct {
+	message  string
+	location *ast.Location
+}
+
+type lintOptions struct {
+	policyIDPattern   *regexp.Regexp
+	disabled          []string
+	failOn            diagnostic.Severity
+	diagnosticsFormat string
+}
+
+// lintRule checks all policies at once, so that rules can compare policies
+// with each other.
+type lintRule struct {
+	name        string
+	description string
+	severity    diagnostic.Severity
+	check       func(policies []rego.Rego, options lintOptions) []lintFinding
+}
+
+var lintRules = []lintRule{
+	{
+		name:        "missing-title",
+		description: "The policy has no title, so it is left out of the documentation",
+		severity:    diagnostic.Warning,
+		check: eachPolicy(func(policy rego.Rego, _ lintOptions) []string {
+			if policy.Title() == "" {
+				return []string{"No title set"}
+			}
+			return nil
+		}),
+	},
+	{
+		name:        "missing-description",
+		description: "The policy has no description",
+		severity:    diagnostic.Warning,
+		check: eachPolicy(func(policy rego.Rego, _ lintOptions) []string {
+			if policy.Description() == "" {
+				return []string{"No description set"}
+			}
+			return nil
+		}),
+	},
+	{
+		name:        "missing-kind-matchers",
+		description: "The policy applies to all kinds, which can lead to poor policy performance",
+		severity:    diagnostic.Warning,
+		check: eachPolicy(func(policy rego.Rego, _ lintOptions) []string {
+			if len(policy.AnnotationKindMatchers()) == 0 {
+				return []string{"No kind matchers set, this can lead to poor policy performance"}
+			}
+			return nil
+		}),
+	},
+	{
+		name:        "invalid-policy-id",
+		description: "The policyID does not match the pattern set with --policy-id-pattern",
+		severity:    diagnostic.Error,
+		check: eachPolicy(func(policy rego.Rego, options lintOptions) []string {
+			if policy.PolicyID() != "" && !options.policyIDPattern.MatchString(policy.PolicyID()) {
+				return []string{fmt.Sprintf("policyID %q does not match the pattern %s", policy.PolicyID(), options.policyIDPattern)}
+			}
+			return nil
+		}),
+	},
+	{
+		name:        "duplicate-policy-id",
+		description: "The policyID is also used by another policy",
+		severity:    diagnostic.Error,
+		check: func(policies []rego.Rego, _ lintOptions) []lintFinding {
+			var findings []lintFinding
+			seen := make(map[string]string)
+			for _, policy := range policies {
+				if policy.PolicyID() == "" {
+					continue
+				}
+				if other, ok := seen[policy.PolicyID()]; ok {
+					findings = append(findings, lintFinding{
+						message:  fmt.Sprintf("policyID %q is also used by %s", policy.PolicyID(), other),
+						location: policy.Location(),
+					})
+					continue
+				}
+				seen[policy.PolicyID()] = policy.Path()
+			}
+			return findings
+		},
+	},
+	{
+		name:        "unused-parameter",
+		description: "A parameter is declared in the metadata but never read from input.parameters",
+		severity:    diagnostic.Warning,
+		check: func(policies []rego.Rego, _ lintOptions) []lintFinding {
+			var findings []lintFinding
+			for _, policy := range policies {
+				for _, name := range policy.UnusedParameters() {
+					findings = append(findings, lintFinding{
+						message:  fmt.Sprintf("Parameter %q is declared but not used", name),
+						location: policy.ParameterLocation(name),
+					})
+				}
+			}
+			return findings
+		},
+	},
+	{
+		name:        "undetermined-sync-data",
+		description: "The policy reads resources from data.inventory that cannot be determined, and does not set custom.requiresSyncData",
+		severity:    diagnostic.Warning,
+		check: func(policies []rego.Rego, _ lintOptions) []lintFinding {
+			var findings []lintFinding
+			for _, policy := range policies {
+				if location := policy.UndeterminedSyncData(); location != nil {
+					findings = append(findings, lintFinding{message: undeterminedSyncDataMessage, location: location})
+				}
+			}
+			return findings
+		},
+	},
+	{
+		name:        "unknown-annotation",
+		description: "The custom metadata has a key that Konstraint does not know",
+		severity:    diagnostic.Warning,
+		check: eachPolicy(func(policy rego.Rego, _ lintOptions) []string {
+			var messages []string
+			for _, key := range policy.UnknownAnnotationKeys() {
+				messages = append(messages, fmt.Sprintf("Unknown custom annotation %q", key))
+			}
+			return messages
+		}),
+	},
+	{
+		name:        "invalid-enforcement",
+		description: "The enforcement action of the policy or of one of its Constraints is invalid",
+		severity:    diagnostic.Error,
+		check: eachPolicy(func(policy rego.Rego, _ lintOptions) []string {
+			var messages []string
+			if !isValidEnforcementAction(policy.Enforcement()) {
+				messages = append(messages, fmt.Sprintf("Invalid enforcement action %q", policy.Enforcement()))
+			}
+			for _, c := range policy.AnnotationConstraints() {
+				instance, err := policy.ForConstraint(c)
+				if err != nil {
+					messages = append(messages, fmt.Sprintf("Constraint %s: %s", c.Name, err))
+					continue
+				}
+				if !isValidEnforcementAction(instance.Enforcement()) {
+					messages = append(messages, fmt.Sprintf("Invalid enforcement action %q of Constraint %s", instance.Enforcement(), c.Name))
+				}
+			}
+			return messages
+		}),
+	},
+}
+
+// eachPolicy returns a check that runs the given check on every policy on its
+// own, reporting each message at the location of the policy.
+func eachPolicy(check func(policy rego.Rego, options lintOptions) []string) func([]rego.Rego, lintOptions) []lintFinding {
+	return func(policies []rego.Rego, options lintOptions) []lintFinding {
+		var findings []lintFinding
+		for _, policy := range policies {
+			for _, message := range check(policy, options) {
+				findings = append(findings, lintFinding{message: message, location: policy.Location()})
+			}
+		}
+		return findings
+	}
+}
+
+func newLintCommand() *cobra.Command {
+	var rules strings.Builder
+	for _, rule := range lintRules {
+		fmt.Fprintf(&rules, "  %-22s %-8s %s\n", rule.name, rule.severity, rule.description)
+	}
+
+	cmd := cobra.Command{
+		Use:   "lint <dir>",
+		Short: "Check the metadata of Rego policies for common problems",
+		Long: `Lint checks the metadata of the policies with a set of rules, and reports every
+finding with the location of the policy. The command fails when a finding has the
+severity set with --fail-on, or a higher one.
+
+Rules:
+` + rules.String(),
+		Example: `Lint the policies in the examples directory
+	konstraint lint examples
+
+Do not report policies without a description
+	konstraint lint examples --disable missing-description
+
+Fail on warnings as well as on errors
+	konstraint lint examples --fail-on warning`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := viper.BindPFlag("disable", cmd.Flags().Lookup("disable")); err != nil {
+				return fmt.Errorf("bind disable flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("policy-id-pattern", cmd.Flags().Lookup("policy-id-pattern")); err != nil {
+				return fmt.Errorf("bind policy-id-pattern flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("fail-on", cmd.Flags().Lookup("fail-on")); err != nil {
+				return fmt.Errorf("bind fail-on flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("diagnostics-format", cmd.Flags().Lookup("diagnostics-format")); err != nil {
+				return fmt.Errorf("bind diagnostics-format flag: %w", err)
+			}
+
+			for _, name := range viper.GetStringSlice("disable") {
+				if !isLintRule(name) {
+					return fmt.Errorf("unknown lint rule: %s", name)
+				}
+			}
+
+			failOn := diagnostic.Severity(viper.GetString("fail-on"))
+			if failOn != diagnostic.Error && failOn != diagnostic.Warning {
+				return fmt.Errorf("fail-on must be either error or warning")
+			}
+
+			if err := validateDiagnosticsFormat(viper.GetString("diagnostics-format")); err != nil {
+				return err
+			}
+
+			pattern, err := regexp.Compile(viper.GetString("policy-id-pattern"))
+			if err != nil {
+				return fmt.Errorf("compile policy-id-pattern: %w", err)
+			}
+
+			path := "."
+			if len(args) > 0 {
+				path = args[0]
+			}
+
+			// A finding is not a usage error.
+			cmd.SilenceUsage = true
+
+			options := lintOptions{
+				policyIDPattern:   pattern,
+				disabled:          viper.GetStringSlice("disable"),
+				failOn:            failOn,
+				diagnosticsFormat: viper.GetString("diagnostics-format"),
+			}
+
+			return runLintCommand(path, options, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringSlice("disable", nil, "Lint rules to disable")
+	cmd.Flags().String("policy-id-pattern", `^P[0-9]{4}$`, "Regular expression that policyIDs must match")
+	cmd.Flags().String("fail-on", string(diagnostic.Error), "Lowest severity of the findings that fail the command. Options: error, warning")
+	cmd.Flags().String("diagnostics-format", "", "Report the findings in this format instead of as text. Options: json, sarif, github")
+
+	return &cmd
+}
+
+func runLintCommand(path string, options lintOptions, out io.Writer) error {
+	var diagnostics diagnostic.List
+
+	policies, err := rego.GetAllSeveritiesWithoutImports(path)
+	if err := addDiagnostics(&diagnostics, err); err != nil {
+		return fmt.Errorf("get all severities: %w", err)
+	}
+
+	for _, rule := range lintRules {
+		if contains(options.disabled, rule.name) {
+			log.WithField("rule", rule.name).Debug("Skipping disabled lint rule")
+			continue
+		}
+
+		for _, finding := range rule.check(policies, options) {
+			diagnostics.Add(diagnostic.New(rule.name, rule.severity, finding.location, finding.message))
+		}
+	}
+	diagnostics.Sort()
+
+	if options.diagnosticsFormat != "" {
+		if err := diagnostic.Write(out, options.diagnosticsFormat, diagnostics); err != nil {
+			return fmt.Errorf("write diagnostics: %w", err)
+		}
+	} else {
+		for _, d := range diagnostics {
+			fmt.Fprintln(out, d)
+		}
+	}
+
+	errors := diagnostics.Errors()
+	warnings := len(diagnostics) - errors
+	if errors > 0 || (options.failOn == diagnostic.Warning && warnings > 0) {
+		return fmt.Errorf("found %d error(s) and %d warning(s)", errors, warnings)
+	}
+
+	log.WithFields(log.Fields{
+		"num_policies": len(policies),
+		"num_warnings": warnings,
+	}).Info("completed successfully")
+
+	return nil
+}
+
+func isLintRule(name string) bool {
+	for _, rule := range lintRules {
+		if rule.name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(collection []string, item string) bool {
+	for _, value := range collection {
+		if value == item {
+			return true
+		}
+	}
+
+	return false
+}
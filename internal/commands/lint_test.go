@@ -0,0 +1,157 @@
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/plexsystems/konstraint/internal/diagnostic"
+)
+
+func TestRunLintCommand(t *testing.T) {
+	policies := map[string]string{
+		"lib/core.rego": `package lib.core
+
+resource := input.review.object
+`,
+		"complete/src.rego": `# METADATA
+# title: Complete
+# description: A policy with all metadata.
+# custom:
+#   matchers:
+#     kinds:
+#     - apiGroups: [""]
+#       kinds: ["Pod"]
+#   parameters:
+#     labels:
+#       type: array
+#       items:
+#         type: string
+package complete
+
+policyID := "P0001"
+
+violation[msg] {
+	input.parameters.labels[_]
+	msg := "complete"
+}
+`,
+		"incomplete/src.rego": `# METADATA
+# custom:
+#   enforcment: warn
+#   enforcement: block
+#   parameters:
+#     unused:
+#       type: string
+package incomplete
+
+policyID := "P0001"
+
+violation[msg] {
+	msg := "incomplete"
+}
+`,
+		"invalid_id/src.rego": `# METADATA
+# title: Invalid policyID
+# description: A policy with an invalid policyID.
+# custom:
+#   matchers:
+#     kinds:
+#     - apiGroups: [""]
+#       kinds: ["Pod"]
+package invalid_id
+
+policyID := "POLICY-1"
+
+violation[msg] {
+	msg := "invalid"
+}
+`,
+	}
+
+	dir := t.TempDir()
+	for name, content := range policies {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	incomplete := filepath.Join(dir, "incomplete", "src.rego")
+	invalidID := filepath.Join(dir, "invalid_id", "src.rego")
+
+	testCases := []struct {
+		desc     string
+		disabled []string
+		failOn   diagnostic.Severity
+		expected []string
+		wantErr  bool
+	}{
+		{
+			desc:   "All rules",
+			failOn: diagnostic.Error,
+			expected: []string{
+				incomplete + ":1:1: warning: No title set (missing-title)",
+				incomplete + ":1:1: warning: No description set (missing-description)",
+				incomplete + ":1:1: warning: No kind matchers set, this can lead to poor policy performance (missing-kind-matchers)",
+				incomplete + `:1:1: error: policyID "P0001" is also used by ` + filepath.Join(dir, "complete", "src.rego") + " (duplicate-policy-id)",
+				incomplete + `:1:1: warning: Parameter "unused" is declared but not used (unused-parameter)`,
+				incomplete + `:1:1: warning: Unknown custom annotation "enforcment" (unknown-annotation)`,
+				incomplete + `:1:1: error: Invalid enforcement action "block" (invalid-enforcement)`,
+				invalidID + `:1:1: error: policyID "POLICY-1" does not match the pattern ^P[0-9]{4}$ (invalid-policy-id)`,
+			},
+			wantErr: true,
+		},
+		{
+			desc:     "Only warnings",
+			disabled: []string{"duplicate-policy-id", "invalid-enforcement", "invalid-policy-id"},
+			failOn:   diagnostic.Error,
+			expected: []string{
+				incomplete + ":1:1: warning: No title set (missing-title)",
+				incomplete + ":1:1: warning: No description set (missing-description)",
+				incomplete + ":1:1: warning: No kind matchers set, this can lead to poor policy performance (missing-kind-matchers)",
+				incomplete + `:1:1: warning: Parameter "unused" is declared but not used (unused-parameter)`,
+				incomplete + `:1:1: warning: Unknown custom annotation "enforcment" (unknown-annotation)`,
+			},
+		},
+		{
+			desc:     "Fail on warnings",
+			disabled: []string{"duplicate-policy-id", "invalid-enforcement", "invalid-policy-id", "missing-title", "missing-description", "missing-kind-matchers", "unused-parameter"},
+			failOn:   diagnostic.Warning,
+			expected: []string{
+				incomplete + `:1:1: warning: Unknown custom annotation "enforcment" (unknown-annotation)`,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			options := lintOptions{
+				policyIDPattern: regexp.MustCompile(`^P[0-9]{4}$`),
+				disabled:        tc.disabled,
+				failOn:          tc.failOn,
+			}
+
+			var out bytes.Buffer
+			err := runLintCommand(dir, options, &out)
+			if tc.wantErr && err == nil {
+				t.Errorf("expected error, got none")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+
+			actual := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if strings.Join(actual, "\n") != strings.Join(tc.expected, "\n") {
+				t.Errorf("unexpected findings:\n%s\nwant:\n%s", strings.Join(actual, "\n"), strings.Join(tc.expected, "\n"))
+			}
+		})
+	}
+}
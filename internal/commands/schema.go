@@ -0,0 +1,33 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/plexsystems/konstraint/internal/rego"
+
+	"github.com/spf13/cobra"
+)
+
+func newSchemaCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of the custom METADATA annotations",
+		Long: `Schema prints the JSON Schema that the custom section of the METADATA
+annotations of every policy is validated against. Editors can use it to
+complete and check the custom section.`,
+		Example: `Save the schema to a file
+	konstraint schema > konstraint.schema.json`,
+		Args: cobra.NoArgs,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := os.Stdout.Write(rego.CustomSchema); err != nil {
+				return fmt.Errorf("write schema: %w", err)
+			}
+
+			return nil
+		},
+	}
+
+	return &cmd
+}
@@ -0,0 +1,554 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/plexsystems/konstraint/internal/rego"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/cover"
+	"github.com/open-policy-agent/opa/loader"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/tester"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func newTestCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "test <dir>",
+		Short: "Run the Rego unit tests of the policies and libraries",
+		Long: `Test runs the Rego unit tests in the files ending with _test.rego with the OPA
+tester. The tests are loaded together with the policies and libraries, and the
+result and line coverage are reported for every policy Kind and every library.`,
+		Example: `Run the tests of the policies in the examples directory
+	konstraint test examples
+
+Load data, such as the inventory of Gatekeeper, for the tests
+	konstraint test examples --data examples/test-data
+
+Fail when the coverage of a policy or library is below 80%
+	konstraint test examples --threshold 80
+
+Write JUnit and JSON reports for CI
+	konstraint test examples --junit-output report.xml --json-output report.json`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := viper.BindPFlag("data", cmd.Flags().Lookup("data")); err != nil {
+				return fmt.Errorf("bind data flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("run", cmd.Flags().Lookup("run")); err != nil {
+				return fmt.Errorf("bind run flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("threshold", cmd.Flags().Lookup("threshold")); err != nil {
+				return fmt.Errorf("bind threshold flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("junit-output", cmd.Flags().Lookup("junit-output")); err != nil {
+				return fmt.Errorf("bind junit-output flag: %w", err)
+			}
+
+			if err := viper.BindPFlag("json-output", cmd.Flags().Lookup("json-output")); err != nil {
+				return fmt.Errorf("bind json-output flag: %w", err)
+			}
+
+			threshold := viper.GetFloat64("threshold")
+			if threshold < 0 || threshold > 100 {
+				return fmt.Errorf("threshold must be between 0 and 100, got %v", threshold)
+			}
+
+			path := "."
+			if len(args) > 0 {
+				path = args[0]
+			}
+
+			// A failing test is not a usage error.
+			cmd.SilenceUsage = true
+
+			options := testOptions{
+				data:        viper.GetStringSlice("data"),
+				run:         viper.GetString("run"),
+				threshold:   threshold,
+				junitOutput: viper.GetString("junit-output"),
+				jsonOutput:  viper.GetString("json-output"),
+			}
+
+			return runTestCommand(path, options, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringSlice("data", nil, "Files or directories with JSON or YAML data to load for the tests")
+	cmd.Flags().String("run", "", "Only run the tests whose names match this regular expression")
+	cmd.Flags().Float64("threshold", 0, "Lowest line coverage in percent of every policy and library")
+	cmd.Flags().String("junit-output", "", "Write a JUnit XML report of the tests to this file")
+	cmd.Flags().String("json-output", "", "Write a JSON report of the tests and their coverage to this file")
+
+	return &cmd
+}
+
+type testOptions struct {
+	data        []string
+	run         string
+	threshold   float64
+	junitOutput string
+	jsonOutput  string
+}
+
+// testUnit is a policy or library, along with its tests and their coverage.
+type testUnit struct {
+	Name            string       `json:"name"`
+	Type            string       `json:"type"`
+	Path            string       `json:"path"`
+	Tests           []testResult `json:"tests"`
+	Coverage        float64      `json:"coverage"`
+	CoveredLines    int          `json:"coveredLines"`
+	NotCoveredLines int          `json:"notCoveredLines"`
+
+	files []string
+}
+
+// Types of test units.
+const (
+	testUnitPolicy  = "policy"
+	testUnitLibrary = "library"
+)
+
+// Outcomes of a test.
+const (
+	testPass  = "pass"
+	testFail  = "fail"
+	testError = "error"
+	testSkip  = "skip"
+)
+
+// testResult is the outcome of a single test.
+type testResult struct {
+	Package  string        `json:"package"`
+	Name     string        `json:"name"`
+	File     string        `json:"file"`
+	Line     int           `json:"line"`
+	Outcome  string        `json:"outcome"`
+	Message  string        `json:"message,omitempty"`
+	Output   string        `json:"output,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+func (t testResult) String() string {
+	return fmt.Sprintf("%s.%s (%s:%d)", t.Package, t.Name, t.File, t.Line)
+}
+
+func (u *testUnit) failed() bool {
+	for _, t := range u.Tests {
+		if t.Outcome == testFail || t.Outcome == testError {
+			return true
+		}
+	}
+
+	return false
+}
+
+// belowThreshold returns whether the coverage of the unit is below the
+// threshold. Units without any lines to cover are never below it.
+func (u *testUnit) belowThreshold(threshold float64) bool {
+	return u.CoveredLines+u.NotCoveredLines > 0 && u.Coverage < threshold
+}
+
+func runTestCommand(path string, options testOptions, out io.Writer) error {
+	ctx := context.Background()
+
+	modules, err := rego.LoadModules(path)
+	if err != nil {
+		return fmt.Errorf("load modules: %w", err)
+	}
+
+	policies, err := rego.GetAllSeveritiesWithoutImports(path)
+	if err != nil {
+		return fmt.Errorf("get all severities: %w", err)
+	}
+
+	store, err := loadData(options.data)
+	if err != nil {
+		return fmt.Errorf("load data: %w", err)
+	}
+
+	coverage := cover.New()
+	runner := tester.NewRunner().
+		SetCompiler(ast.NewCompiler().WithEnablePrintStatements(true)).
+		SetStore(store).
+		SetModules(modules).
+		SetCoverageQueryTracer(coverage).
+		CapturePrintOutput(true).
+		Filter(options.run)
+
+	results, err := runner.RunTests(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("run tests: %w", err)
+	}
+
+	units, unitsByDir, unitsByPackage := getTestUnits(modules, policies)
+	for result := range results {
+		test := toTestResult(result)
+
+		unit, ok := unitsByDir[filepath.Dir(test.File)]
+		if !ok {
+			unit = unitsByPackage[test.Package]
+		}
+		if unit == nil {
+			unit = &testUnit{Name: test.Package, Type: testUnitLibrary, Path: test.File}
+			unitsByPackage[test.Package] = unit
+			units = append(units, unit)
+		}
+		unit.Tests = append(unit.Tests, test)
+	}
+
+	sourceModules := make(map[string]*ast.Module)
+	for name, module := range modules {
+		if !strings.HasSuffix(name, "_test.rego") {
+			sourceModules[name] = module
+		}
+	}
+	report := coverage.Report(sourceModules)
+
+	var numTests, failed int
+	for _, unit := range units {
+		for _, file := range unit.files {
+			if fileReport, ok := report.Files[file]; ok {
+				unit.CoveredLines += fileReport.CoveredLines
+				unit.NotCoveredLines += fileReport.NotCoveredLines
+			}
+		}
+		if total := unit.CoveredLines + unit.NotCoveredLines; total > 0 {
+			unit.Coverage = 100 * float64(unit.CoveredLines) / float64(total)
+		}
+
+		sort.Slice(unit.Tests, func(i, j int) bool {
+			if unit.Tests[i].File != unit.Tests[j].File {
+				return unit.Tests[i].File < unit.Tests[j].File
+			}
+			return unit.Tests[i].Line < unit.Tests[j].Line
+		})
+		numTests += len(unit.Tests)
+
+		if unit.failed() || unit.belowThreshold(options.threshold) {
+			failed++
+		}
+		writeTestUnit(out, unit, options.threshold)
+	}
+
+	if options.junitOutput != "" {
+		if err := writeReport(options.junitOutput, func(w io.Writer) error { return writeJUnitReport(w, units, options.threshold) }); err != nil {
+			return fmt.Errorf("write JUnit report: %w", err)
+		}
+	}
+	if options.jsonOutput != "" {
+		if err := writeReport(options.jsonOutput, func(w io.Writer) error { return writeJSONReport(w, units, report.Coverage) }); err != nil {
+			return fmt.Errorf("write JSON report: %w", err)
+		}
+	}
+
+	if numTests == 0 {
+		log.Warn("No tests found")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d policies and libraries failed", failed, len(units))
+	}
+
+	log.WithFields(log.Fields{
+		"num_tests": numTests,
+		"coverage":  fmt.Sprintf("%.1f%%", report.Coverage),
+	}).Info("completed successfully")
+
+	return nil
+}
+
+// getTestUnits returns a unit for every policy, and for every package of the
+// other modules, which are libraries. The files of a policy are the files in
+// its directory.
+func getTestUnits(modules map[string]*ast.Module, policies []rego.Rego) ([]*testUnit, map[string]*testUnit, map[string]*testUnit) {
+	var units []*testUnit
+	unitsByDir := make(map[string]*testUnit)
+	for _, policy := range policies {
+		unit := &testUnit{Name: policy.Kind(), Type: testUnitPolicy, Path: policy.Path()}
+		unitsByDir[filepath.Dir(policy.Path())] = unit
+		units = append(units, unit)
+	}
+
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	unitsByPackage := make(map[string]*testUnit)
+	var libraries []*testUnit
+	for _, name := range names {
+		if strings.HasSuffix(name, "_test.rego") {
+			continue
+		}
+
+		if unit, ok := unitsByDir[filepath.Dir(name)]; ok {
+			unit.files = append(unit.files, name)
+			continue
+		}
+
+		pkg := modules[name].Package.Path.String()
+		unit, ok := unitsByPackage[pkg]
+		if !ok {
+			unit = &testUnit{Name: strings.TrimPrefix(pkg, "data."), Type: testUnitLibrary, Path: name}
+			unitsByPackage[pkg] = unit
+			libraries = append(libraries, unit)
+		}
+		unit.files = append(unit.files, name)
+	}
+
+	sort.Slice(units, func(i, j int) bool {
+		return units[i].Path < units[j].Path
+	})
+
+	return append(units, libraries...), unitsByDir, unitsByPackage
+}
+
+func toTestResult(result *tester.Result) testResult {
+	test := testResult{
+		Package:  result.Package,
+		Name:     result.Name,
+		Outcome:  testPass,
+		Output:   string(result.Output),
+		Duration: result.Duration,
+	}
+	if result.Location != nil {
+		test.File = result.Location.File
+		test.Line = result.Location.Row
+	}
+
+	switch {
+	case result.Skip:
+		test.Outcome = testSkip
+	case result.Error != nil:
+		test.Outcome = testError
+		test.Message = result.Error.Error()
+	case result.Fail:
+		test.Outcome = testFail
+		if result.FailedAt != nil {
+			test.Message = fmt.Sprintf("failed at %s", result.FailedAt)
+		}
+	}
+
+	return test
+}
+
+func writeTestUnit(out io.Writer, unit *testUnit, threshold float64) {
+	status := "PASS"
+	switch {
+	case unit.failed() || unit.belowThreshold(threshold):
+		status = "FAIL"
+	case len(unit.Tests) == 0:
+		status = "NONE"
+	}
+
+	fmt.Fprintf(out, "%s %s (%s): %d test(s), %.1f%% coverage\n", status, unit.Name, unit.Path, len(unit.Tests), unit.Coverage)
+	for _, test := range unit.Tests {
+		if test.Outcome != testFail && test.Outcome != testError {
+			continue
+		}
+
+		fmt.Fprintf(out, "  %s %s\n", strings.ToUpper(test.Outcome), test)
+		if test.Message != "" {
+			fmt.Fprintf(out, "    %s\n", test.Message)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(test.Output), "\n") {
+			if line != "" {
+				fmt.Fprintf(out, "    %s\n", line)
+			}
+		}
+	}
+	if unit.belowThreshold(threshold) {
+		fmt.Fprintf(out, "  coverage %.1f%% is below the threshold of %.1f%%\n", unit.Coverage, threshold)
+	}
+}
+
+func loadData(paths []string) (storage.Store, error) {
+	if len(paths) == 0 {
+		return inmem.New(), nil
+	}
+
+	result, err := loader.NewFileLoader().Filtered(paths, func(_ string, info os.FileInfo, _ int) bool {
+		switch filepath.Ext(info.Name()) {
+		case ".json", ".yaml", ".yml":
+			return false
+		default:
+			return !info.IsDir()
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load data files: %w", err)
+	}
+
+	return inmem.NewFromObject(result.Documents), nil
+}
+
+func writeReport(path string, write func(io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func writeJSONReport(out io.Writer, units []*testUnit, coverage float64) error {
+	report := struct {
+		Coverage float64     `json:"coverage"`
+		Units    []*testUnit `json:"units"`
+	}{coverage, units}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return fmt.Errorf("encode report: %w", err)
+	}
+
+	return nil
+}
+
+// The subset of the JUnit XML format that CI systems read.
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	TestCases  []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Line      int           `xml:"line,attr,omitempty"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+// writeJUnitReport writes a test suite for every policy and library. A
+// coverage below the threshold is reported as a failed coverage test case.
+func writeJUnitReport(out io.Writer, units []*testUnit, threshold float64) error {
+	var suites junitTestSuites
+	for _, unit := range units {
+		suite := junitTestSuite{
+			Name:       unit.Name,
+			Properties: []junitProperty{{Name: "coverage", Value: fmt.Sprintf("%.1f", unit.Coverage)}},
+		}
+
+		var duration time.Duration
+		for _, test := range unit.Tests {
+			duration += test.Duration
+
+			testCase := junitTestCase{
+				ClassName: test.Package,
+				Name:      test.Name,
+				File:      test.File,
+				Line:      test.Line,
+				Time:      junitTime(test.Duration),
+				SystemOut: test.Output,
+			}
+			switch test.Outcome {
+			case testFail:
+				testCase.Failure = &junitMessage{Message: test.Message}
+				suite.Failures++
+			case testError:
+				testCase.Error = &junitMessage{Message: test.Message}
+				suite.Errors++
+			case testSkip:
+				testCase.Skipped = &junitMessage{}
+				suite.Skipped++
+			}
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+
+		if threshold > 0 {
+			testCase := junitTestCase{ClassName: unit.Name, Name: "coverage", Time: junitTime(0)}
+			if unit.belowThreshold(threshold) {
+				testCase.Failure = &junitMessage{Message: fmt.Sprintf("coverage %.1f%% is below the threshold of %.1f%%", unit.Coverage, threshold)}
+				suite.Failures++
+			}
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+
+		suite.Time = junitTime(duration)
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	return encodeJUnit(out, suites)
+}
+
+// encodeJUnit writes the test suites as JUnit XML, along with the number of
+// tests of every suite and the totals of all suites.
+func encodeJUnit(out io.Writer, suites junitTestSuites) error {
+	for i := range suites.Suites {
+		suite := &suites.Suites[i]
+		suite.Tests = len(suite.TestCases)
+
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+	}
+
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	encoder := xml.NewEncoder(out)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return fmt.Errorf("encode report: %w", err)
+	}
+	if _, err := io.WriteString(out, "\n"); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+
+	return nil
+}
+
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
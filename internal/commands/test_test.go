@@ -0,0 +1,165 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunTestCommand(t *testing.T) {
+	files := map[string]string{
+		"lib/core.rego": `package lib.core
+
+import future.keywords.if
+
+is_pod if input.review.object.kind == "Pod"
+
+is_deployment if input.review.object.kind == "Deployment"
+`,
+		"lib/core_test.rego": `package lib.core
+
+import future.keywords.if
+
+test_is_pod if is_pod with input as {"review": {"object": {"kind": "Pod"}}}
+`,
+		"pod_deny_all/src.rego": `package pod_deny_all
+
+import data.lib.core
+import future.keywords.contains
+import future.keywords.if
+
+policyID := "P0001"
+
+violation contains msg if {
+	core.is_pod
+	not data.inventory.cluster.v1.Namespace[input.review.object.metadata.namespace]
+	msg := "pods are not allowed"
+}
+`,
+		"pod_deny_all/src_test.rego": `package pod_deny_all
+
+import future.keywords.if
+
+test_pod if {
+	violation with input as {"review": {"object": {"kind": "Pod", "metadata": {"namespace": "unknown"}}}}
+}
+
+test_pod_in_namespace if {
+	count(violation) == 0 with input as {"review": {"object": {"kind": "Pod", "metadata": {"namespace": "default"}}}}
+}
+`,
+		"data/inventory.json": `{"inventory": {"cluster": {"v1": {"Namespace": {"default": {}}}}}}`,
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	policy := filepath.Join(dir, "pod_deny_all", "src.rego")
+	library := filepath.Join(dir, "lib", "core.rego")
+
+	testCases := []struct {
+		desc     string
+		options  testOptions
+		expected []string
+		wantErr  bool
+	}{
+		{
+			desc:    "Without data",
+			options: testOptions{},
+			expected: []string{
+				"FAIL PodDenyAll (" + policy + "): 2 test(s), 80.0% coverage",
+				"  FAIL data.pod_deny_all.test_pod_in_namespace (" + filepath.Join(dir, "pod_deny_all", "src_test.rego") + ":9)",
+				"PASS lib.core (" + library + "): 1 test(s), 50.0% coverage",
+			},
+			wantErr: true,
+		},
+		{
+			desc:    "With data",
+			options: testOptions{data: []string{filepath.Join(dir, "data")}},
+			expected: []string{
+				"PASS PodDenyAll (" + policy + "): 2 test(s), 80.0% coverage",
+				"PASS lib.core (" + library + "): 1 test(s), 50.0% coverage",
+			},
+		},
+		{
+			desc:    "Below threshold",
+			options: testOptions{data: []string{filepath.Join(dir, "data")}, threshold: 80},
+			expected: []string{
+				"PASS PodDenyAll (" + policy + "): 2 test(s), 80.0% coverage",
+				"FAIL lib.core (" + library + "): 1 test(s), 50.0% coverage",
+				"  coverage 50.0% is below the threshold of 80.0%",
+			},
+			wantErr: true,
+		},
+		{
+			desc:    "Filtered",
+			options: testOptions{run: "test_pod$"},
+			expected: []string{
+				"PASS PodDenyAll (" + policy + "): 1 test(s), 80.0% coverage",
+				"NONE lib.core (" + library + "): 0 test(s), 50.0% coverage",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var out bytes.Buffer
+			err := runTestCommand(dir, tc.options, &out)
+			if tc.wantErr && err == nil {
+				t.Errorf("expected error, got none")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+
+			actual := strings.TrimSpace(out.String())
+			expected := strings.Join(tc.expected, "\n")
+			if actual != expected {
+				t.Errorf("unexpected output:\n%s\nwant:\n%s", actual, expected)
+			}
+		})
+	}
+}
+
+func TestWriteJUnitReport(t *testing.T) {
+	units := []*testUnit{
+		{
+			Name:     "PodDenyAll",
+			Coverage: 50,
+			Tests: []testResult{
+				{Package: "data.pod_deny_all", Name: "test_pod", Outcome: testPass},
+				{Package: "data.pod_deny_all", Name: "test_pod_in_namespace", Outcome: testFail, Message: "failed"},
+			},
+			CoveredLines:    1,
+			NotCoveredLines: 1,
+		},
+	}
+
+	var out bytes.Buffer
+	if err := writeJUnitReport(&out, units, 80); err != nil {
+		t.Fatal(err)
+	}
+
+	var report junitTestSuites
+	if err := xml.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("unmarshal report: %s", err)
+	}
+
+	if report.Tests != 3 || report.Failures != 2 {
+		t.Errorf("expected 3 tests and 2 failures, got %d tests and %d failures", report.Tests, report.Failures)
+	}
+	if len(report.Suites) != 1 || report.Suites[0].TestCases[2].Name != "coverage" {
+		t.Errorf("expected a coverage test case, got %+v", report.Suites)
+	}
+}
@@ -0,0 +1,453 @@
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/plexsystems/konstraint/internal/diagnostic"
+	"github.com/plexsystems/konstraint/internal/rego"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// Targets of the create command: Gatekeeper, or the ValidatingAdmissionPolicy
+// of Kubernetes.
+const (
+	targetGatekeeper = "gatekeeper"
+	targetVAP        = "vap"
+)
+
+var createTargets = []string{targetGatekeeper, targetVAP}
+
+// The Constraints of the policies are the parameters of their
+// ValidatingAdmissionPolicies, as in Gatekeeper, so that the same CEL can be
+// used with both.
+const constraintGroup = "constraints.gatekeeper.sh"
+
+// namespaceNameLabel is the label that Kubernetes sets on every Namespace with
+// its name.
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+// Variables that Gatekeeper declares for the CEL of a ConstraintTemplate.
+var gatekeeperVariables = []admissionregistrationv1.Variable{
+	{Name: "params", Expression: "!has(params.spec) ? null : !has(params.spec.parameters) ? null : params.spec.parameters"},
+	{Name: "anyObject", Expression: `has(request.operation) && request.operation == "DELETE" && object == null ? oldObject : object`},
+}
+
+// renderValidatingAdmissionPolicy renders the ValidatingAdmissionPolicy of a
+// policy with CEL validations, a ValidatingAdmissionPolicyBinding for each of
+// its Constraints, and, when it has parameters, the CRD of its Constraints
+// along with the Constraints that hold the parameters.
+func renderValidatingAdmissionPolicy(violation rego.Rego, logger *log.Entry, diagnostics *diagnostic.List) ([]generatedFile, error) {
+	if violation.CEL() == nil {
+		diagnostics.Add(diagnostic.Warningf(diagnostic.CodeMissingCEL, violation.Location(), "Policy has no %s, skipping ValidatingAdmissionPolicy generation", rego.CELFileName))
+		return nil, nil
+	}
+
+	outputDir := filepath.Dir(violation.Path())
+	if viper.GetString("output") != "" {
+		outputDir = viper.GetString("output")
+	}
+	fileName := func(kind string, name string) string {
+		if viper.GetString("output") != "" {
+			if name != "" {
+				return filepath.Join(outputDir, fmt.Sprintf("%s_%s_%s.yaml", kind, violation.Kind(), name))
+			}
+			return filepath.Join(outputDir, fmt.Sprintf("%s_%s.yaml", kind, violation.Kind()))
+		}
+		if name != "" {
+			return filepath.Join(outputDir, fmt.Sprintf("%s_%s.yaml", kind, name))
+		}
+		return filepath.Join(outputDir, kind+".yaml")
+	}
+
+	// The names of the Constraints declared in the metadata are used in the
+	// file names, like for the Gatekeeper target.
+	instances := []rego.Rego{violation}
+	names := []string{""}
+	if len(violation.AnnotationConstraints()) > 0 {
+		instances, names = nil, nil
+		for _, c := range violation.AnnotationConstraints() {
+			instance, err := violation.ForConstraint(c)
+			if err != nil {
+				return nil, fmt.Errorf("get constraint %s: %w", c.Name, err)
+			}
+			instances = append(instances, instance)
+			names = append(names, c.Name)
+		}
+	}
+
+	policyBytes, err := yaml.Marshal(getValidatingAdmissionPolicy(violation, instances))
+	if err != nil {
+		return nil, fmt.Errorf("marshal ValidatingAdmissionPolicy: %w", err)
+	}
+	files := []generatedFile{{path: fileName("vap", ""), content: policyBytes}}
+
+	hasParameters := len(violation.AnnotationParameters()) > 0
+	if hasParameters {
+		crd, err := toUnstructured(getConstraintCRD(violation))
+		if err != nil {
+			return nil, fmt.Errorf("convert CRD: %w", err)
+		}
+		delete(crd.(map[string]any), "status")
+
+		crdBytes, err := yaml.Marshal(crd)
+		if err != nil {
+			return nil, fmt.Errorf("marshal CRD: %w", err)
+		}
+		files = append(files, generatedFile{path: fileName("crd", ""), content: crdBytes})
+	}
+
+	if viper.GetBool("skip-constraints") || violation.SkipConstraint() {
+		logger.Info("Skipping binding generation due to configuration")
+		return files, nil
+	}
+
+	// Without Constraints with parameter values, the bindings would not have
+	// parameters to refer to.
+	if len(violation.AnnotationConstraints()) == 0 && hasParameters && !viper.GetBool("partial-constraints") {
+		logger.Warn("Skipping binding generation due to use of parameters")
+		return files, nil
+	}
+
+	for i, instance := range instances {
+		name := names[i]
+
+		binding, err := getValidatingAdmissionPolicyBinding(instance)
+		if err != nil {
+			return nil, fmt.Errorf("get ValidatingAdmissionPolicyBinding %s: %w", instance.Name(), err)
+		}
+		if binding == nil {
+			logger.WithField("constraint", instance.Name()).Info("Skipping binding of a constraint that is not enforced at " + rego.EnforcementPointVAP)
+			continue
+		}
+
+		bindingBytes, err := yaml.Marshal(binding)
+		if err != nil {
+			return nil, fmt.Errorf("marshal ValidatingAdmissionPolicyBinding: %w", err)
+		}
+		files = append(files, generatedFile{path: fileName("vapbinding", name), content: bindingBytes})
+
+		if hasParameters {
+			constraintBytes, err := renderConstraint(instance, "", logger, diagnostics)
+			if err != nil {
+				return nil, fmt.Errorf("rendering Constraint %s: %w", instance.Name(), err)
+			}
+			files = append(files, generatedFile{path: fileName("constraint", name), content: constraintBytes})
+		}
+	}
+
+	return files, nil
+}
+
+// getValidatingAdmissionPolicy returns the ValidatingAdmissionPolicy of a
+// policy, which declares the same variables as Gatekeeper does for its CEL.
+func getValidatingAdmissionPolicy(violation rego.Rego, instances []rego.Rego) *admissionregistrationv1.ValidatingAdmissionPolicy {
+	cel := violation.CEL()
+
+	failurePolicy := admissionregistrationv1.Fail
+	if cel.FailurePolicy != "" {
+		failurePolicy = admissionregistrationv1.FailurePolicyType(cel.FailurePolicy)
+	}
+
+	// The policy matches the resources of all of its Constraints, which are
+	// narrowed down by their bindings.
+	var kinds []rego.AnnoKindMatcher
+	seen := make(map[string]bool)
+	for _, instance := range append([]rego.Rego{violation}, instances...) {
+		if len(instance.AnnotationKindMatchers()) == 0 {
+			kinds = nil
+			break
+		}
+		for _, k := range instance.AnnotationKindMatchers() {
+			if !seen[k.String()] {
+				seen[k.String()] = true
+				kinds = append(kinds, k)
+			}
+		}
+	}
+
+	policy := admissionregistrationv1.ValidatingAdmissionPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "admissionregistration.k8s.io/v1",
+			Kind:       "ValidatingAdmissionPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        violation.TemplateName(),
+			Annotations: violation.TemplateAnnotations(),
+			Labels:      violation.TemplateLabels(),
+		},
+		Spec: admissionregistrationv1.ValidatingAdmissionPolicySpec{
+			FailurePolicy: &failurePolicy,
+			MatchConstraints: &admissionregistrationv1.MatchResources{
+				ResourceRules: getResourceRules(kinds, "", violation.AnnotationOperations()),
+			},
+		},
+	}
+
+	variables := append([]admissionregistrationv1.Variable{}, gatekeeperVariables...)
+	if len(violation.AnnotationParameters()) > 0 {
+		policy.Spec.ParamKind = &admissionregistrationv1.ParamKind{
+			APIVersion: constraintGroup + "/v1beta1",
+			Kind:       violation.Kind(),
+		}
+	} else {
+		variables[0].Expression = "null"
+	}
+	for _, v := range cel.Variables {
+		variables = append(variables, admissionregistrationv1.Variable{Name: v.Name, Expression: v.Expression})
+	}
+	policy.Spec.Variables = variables
+
+	for _, c := range cel.MatchConditions {
+		policy.Spec.MatchConditions = append(policy.Spec.MatchConditions, admissionregistrationv1.MatchCondition{Name: c.Name, Expression: c.Expression})
+	}
+	for _, v := range cel.Validations {
+		policy.Spec.Validations = append(policy.Spec.Validations, admissionregistrationv1.Validation{
+			Expression:        v.Expression,
+			Message:           v.Message,
+			MessageExpression: v.MessageExpression,
+		})
+	}
+
+	return &policy
+}
+
+// getValidatingAdmissionPolicyBinding returns the binding of a Constraint of
+// the policy, or nil if the Constraint is not enforced by
+// ValidatingAdmissionPolicies.
+func getValidatingAdmissionPolicyBinding(instance rego.Rego) (*admissionregistrationv1.ValidatingAdmissionPolicyBinding, error) {
+	actions, err := getValidationActions(instance)
+	if err != nil {
+		return nil, err
+	}
+	if len(actions) == 0 {
+		return nil, nil
+	}
+
+	match := instance.AnnotationMatchers()
+	if match.Name != "" {
+		return nil, fmt.Errorf("the name matcher cannot be used with a ValidatingAdmissionPolicyBinding")
+	}
+	if match.Source != "" && match.Source != "All" {
+		return nil, fmt.Errorf("the source matcher cannot be used with a ValidatingAdmissionPolicyBinding")
+	}
+
+	namespaceSelector, err := getNamespaceSelector(match)
+	if err != nil {
+		return nil, err
+	}
+
+	binding := admissionregistrationv1.ValidatingAdmissionPolicyBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "admissionregistration.k8s.io/v1",
+			Kind:       "ValidatingAdmissionPolicyBinding",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        instance.Name(),
+			Annotations: instance.Annotations(),
+			Labels:      instance.Labels(),
+		},
+		Spec: admissionregistrationv1.ValidatingAdmissionPolicyBindingSpec{
+			PolicyName:        instance.TemplateName(),
+			ValidationActions: actions,
+			MatchResources: &admissionregistrationv1.MatchResources{
+				NamespaceSelector: namespaceSelector,
+				ObjectSelector:    match.LabelSelector,
+				ResourceRules:     getResourceRules(match.Kinds, match.Scope, instance.AnnotationOperations()),
+			},
+		},
+	}
+
+	if len(instance.AnnotationParameters()) > 0 {
+		parameterNotFoundAction := admissionregistrationv1.DenyAction
+		binding.Spec.ParamRef = &admissionregistrationv1.ParamRef{
+			Name:                    instance.Name(),
+			ParameterNotFoundAction: &parameterNotFoundAction,
+		}
+	}
+
+	return &binding, nil
+}
+
+// getValidationActions returns the validation actions of a binding for the
+// enforcement actions of a Constraint at the vap.k8s.io enforcement point.
+func getValidationActions(instance rego.Rego) ([]admissionregistrationv1.ValidationAction, error) {
+	enforcementActions := instance.EnforcementActionsAt(rego.EnforcementPointVAP)
+	if viper.GetBool("dryrun") {
+		enforcementActions = []string{"dryrun"}
+	}
+
+	var actions []admissionregistrationv1.ValidationAction
+	for _, action := range enforcementActions {
+		switch action {
+		case "deny":
+			actions = append(actions, admissionregistrationv1.Deny)
+		case "warn":
+			actions = append(actions, admissionregistrationv1.Warn)
+		case "dryrun":
+			actions = append(actions, admissionregistrationv1.Audit)
+		default:
+			return nil, fmt.Errorf("enforcement action %q cannot be used with a ValidatingAdmissionPolicyBinding", action)
+		}
+	}
+
+	if contains(enforcementActions, "deny") && contains(enforcementActions, "warn") {
+		return nil, fmt.Errorf("the deny and warn enforcement actions cannot be used together with a ValidatingAdmissionPolicyBinding")
+	}
+
+	return actions, nil
+}
+
+// getResourceRules returns the rules that match the resources of the kind
+// matchers, or all resources when there are none, for the given operations,
+// or CREATE and UPDATE when there are none. The resources are guessed from
+// the kinds, as the API server is not queried for them.
+func getResourceRules(kinds []rego.AnnoKindMatcher, scope string, operations []string) []admissionregistrationv1.NamedRuleWithOperations {
+	if len(kinds) == 0 {
+		kinds = []rego.AnnoKindMatcher{{APIGroups: []string{"*"}, Kinds: []string{"*"}}}
+	}
+
+	ruleOperations := getOperations(operations)
+	if ruleOperations == nil {
+		ruleOperations = []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update}
+	}
+
+	var ruleScope *admissionregistrationv1.ScopeType
+	if scope != "" {
+		s := admissionregistrationv1.ScopeType(scope)
+		ruleScope = &s
+	}
+
+	var rules []admissionregistrationv1.NamedRuleWithOperations
+	for _, k := range kinds {
+		var resources []string
+		for _, kind := range k.Kinds {
+			if kind == "*" {
+				resources = append(resources, "*")
+				continue
+			}
+			plural, _ := meta.UnsafeGuessKindToResource(schema.GroupVersionKind{Kind: kind})
+			resources = append(resources, plural.Resource)
+		}
+		sort.Strings(resources)
+
+		rules = append(rules, admissionregistrationv1.NamedRuleWithOperations{
+			RuleWithOperations: admissionregistrationv1.RuleWithOperations{
+				Operations: ruleOperations,
+				Rule: admissionregistrationv1.Rule{
+					APIGroups:   k.APIGroups,
+					APIVersions: []string{"*"},
+					Resources:   slices.Compact(resources),
+					Scope:       ruleScope,
+				},
+			},
+		})
+	}
+
+	return rules
+}
+
+// getNamespaceSelector returns the namespace selector of a binding, which
+// selects the namespaces of the namespace matchers by their name label.
+func getNamespaceSelector(match rego.AnnoMatch) (*metav1.LabelSelector, error) {
+	for _, namespace := range append(append([]string{}, match.Namespaces...), match.ExcludedNamespaces...) {
+		if strings.Contains(namespace, "*") {
+			return nil, fmt.Errorf("the namespace pattern %q cannot be used with a ValidatingAdmissionPolicyBinding, only exact names can", namespace)
+		}
+	}
+
+	if len(match.Namespaces) == 0 && len(match.ExcludedNamespaces) == 0 {
+		return match.NamespaceSelector, nil
+	}
+
+	selector := &metav1.LabelSelector{}
+	if match.NamespaceSelector != nil {
+		selector = match.NamespaceSelector.DeepCopy()
+	}
+	if len(match.Namespaces) > 0 {
+		selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      namespaceNameLabel,
+			Operator: metav1.LabelSelectorOpIn,
+			Values:   match.Namespaces,
+		})
+	}
+	if len(match.ExcludedNamespaces) > 0 {
+		selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      namespaceNameLabel,
+			Operator: metav1.LabelSelectorOpNotIn,
+			Values:   match.ExcludedNamespaces,
+		})
+	}
+
+	return selector, nil
+}
+
+// getConstraintCRD returns the CRD of the Constraints of a policy, which
+// Gatekeeper would create from its ConstraintTemplate. Without Gatekeeper,
+// the Constraints hold the parameters of the ValidatingAdmissionPolicy.
+func getConstraintCRD(violation rego.Rego) *apiextensionsv1.CustomResourceDefinition {
+	preserveUnknownFields := true
+	plural := strings.ToLower(violation.Kind())
+
+	return &apiextensionsv1.CustomResourceDefinition{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "apiextensions.k8s.io/v1",
+			Kind:       "CustomResourceDefinition",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: plural + "." + constraintGroup,
+		},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: constraintGroup,
+			Names: apiextensionsv1.CustomResourceDefinitionNames{
+				Kind:       violation.Kind(),
+				ListKind:   violation.Kind() + "List",
+				Plural:     plural,
+				Singular:   plural,
+				Categories: []string{"constraint"},
+			},
+			Scope: apiextensionsv1.ClusterScoped,
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{{
+				Name:    "v1beta1",
+				Served:  true,
+				Storage: true,
+				Schema: &apiextensionsv1.CustomResourceValidation{
+					OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{
+						Type: "object",
+						Properties: map[string]apiextensionsv1.JSONSchemaProps{
+							"spec": {
+								Type: "object",
+								Properties: map[string]apiextensionsv1.JSONSchemaProps{
+									"parameters": {
+										Type:       "object",
+										Properties: violation.AnnotationParameters(),
+									},
+									"enforcementAction": {Type: "string"},
+									"match":             {Type: "object", XPreserveUnknownFields: &preserveUnknownFields},
+									"scopedEnforcementActions": {
+										Type: "array",
+										Items: &apiextensionsv1.JSONSchemaPropsOrArray{
+											Schema: &apiextensionsv1.JSONSchemaProps{Type: "object", XPreserveUnknownFields: &preserveUnknownFields},
+										},
+									},
+								},
+							},
+							"status": {Type: "object", XPreserveUnknownFields: &preserveUnknownFields},
+						},
+					},
+				},
+			}},
+		},
+	}
+}
@@ -0,0 +1,260 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/viper"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/plexsystems/konstraint/internal/rego"
+)
+
+func TestRunCreateCommandVAP(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"required-labels/src.rego": `# METADATA
+# custom:
+#   matchers:
+#     kinds:
+#     - apiGroups: ["apps"]
+#       kinds: ["Deployment"]
+#     namespaces: ["prod"]
+#   parameters:
+#     labels:
+#       type: array
+#       items:
+#         type: string
+#   constraints:
+#   - name: team
+#     parameters:
+#       labels: ["team"]
+#   - name: owner
+#     enforcement: warn
+#     parameters:
+#       labels: ["owner"]
+package required_labels
+
+violation[msg] {
+  input.parameters.labels[_]
+  msg := "foo"
+}
+`,
+		"required-labels/src.cel.yaml": `validations:
+- expression: "variables.params.labels.all(l, l in object.metadata.labels)"
+`,
+		"pod-deny-rego-only/src.rego": "package pod_deny_rego_only\n\nviolation[msg] {\n  msg := \"foo\"\n}\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), os.ModePerm); err != nil {
+			t.Fatalf("create dir: %s", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("write file: %s", err)
+		}
+	}
+
+	t.Cleanup(viper.Reset)
+	output := t.TempDir()
+	viper.Set("output", output)
+	viper.Set("target", targetVAP)
+
+	if err := runCreateCommand(dir); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	entries, err := os.ReadDir(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, entry := range entries {
+		actual = append(actual, entry.Name())
+	}
+	sort.Strings(actual)
+
+	// The policy without CEL is skipped.
+	expected := []string{
+		".konstraint-manifest",
+		"constraint_RequiredLabels_owner.yaml",
+		"constraint_RequiredLabels_team.yaml",
+		"crd_RequiredLabels.yaml",
+		"vap_RequiredLabels.yaml",
+		"vapbinding_RequiredLabels_owner.yaml",
+		"vapbinding_RequiredLabels_team.yaml",
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected files (-want +got):\n%s", diff)
+	}
+
+	content, err := os.ReadFile(filepath.Join(output, "vapbinding_RequiredLabels_owner.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var binding admissionregistrationv1.ValidatingAdmissionPolicyBinding
+	if err := yaml.Unmarshal(content, &binding); err != nil {
+		t.Fatalf("unmarshal binding: %s", err)
+	}
+	if binding.Spec.PolicyName != "requiredlabels" || binding.Spec.ParamRef == nil || binding.Spec.ParamRef.Name != "requiredlabels-owner" {
+		t.Errorf("unexpected binding spec: %+v", binding.Spec)
+	}
+	if diff := cmp.Diff([]admissionregistrationv1.ValidationAction{admissionregistrationv1.Warn}, binding.Spec.ValidationActions); diff != "" {
+		t.Errorf("unexpected validation actions (-want +got):\n%s", diff)
+	}
+}
+
+func TestGetValidatingAdmissionPolicyBinding(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		custom     string
+		actions    []admissionregistrationv1.ValidationAction
+		selector   string
+		operations []admissionregistrationv1.OperationType
+		wantErr    string
+	}{
+		{
+			desc:    "Deny",
+			custom:  "custom: {}",
+			actions: []admissionregistrationv1.ValidationAction{admissionregistrationv1.Deny},
+		},
+		{
+			desc:    "Dryrun",
+			custom:  "custom:\n  enforcement: dryrun",
+			actions: []admissionregistrationv1.ValidationAction{admissionregistrationv1.Audit},
+		},
+		{
+			desc: "Scoped without vap.k8s.io",
+			custom: `custom:
+  scopedEnforcementActions:
+  - action: deny
+    enforcementPoints:
+    - name: validation.gatekeeper.sh`,
+		},
+		{
+			desc: "Scoped",
+			custom: `custom:
+  scopedEnforcementActions:
+  - action: warn
+    enforcementPoints:
+    - name: vap.k8s.io`,
+			actions: []admissionregistrationv1.ValidationAction{admissionregistrationv1.Warn},
+		},
+		{
+			desc: "Namespaces",
+			custom: `custom:
+  matchers:
+    namespaces: ["prod"]
+    excludedNamespaces: ["prod-legacy"]
+    namespaceSelector:
+      matchLabels:
+        team: payments`,
+			actions:  []admissionregistrationv1.ValidationAction{admissionregistrationv1.Deny},
+			selector: "kubernetes.io/metadata.name in (prod),kubernetes.io/metadata.name notin (prod-legacy),team=payments",
+		},
+		{
+			desc:       "Operations",
+			custom:     "custom:\n  operations: [DELETE]",
+			actions:    []admissionregistrationv1.ValidationAction{admissionregistrationv1.Deny},
+			operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Delete},
+		},
+		{
+			desc:    "Namespace pattern",
+			custom:  "custom:\n  matchers:\n    namespaces: [\"prod-*\"]",
+			wantErr: `the namespace pattern "prod-*" cannot be used`,
+		},
+		{
+			desc:    "Name matcher",
+			custom:  "custom:\n  matchers:\n    name: foo",
+			wantErr: "the name matcher cannot be used",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			violation := parsePolicy(t, tc.custom)
+
+			binding, err := getValidatingAdmissionPolicyBinding(violation)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if tc.actions == nil {
+				if binding != nil {
+					t.Errorf("expected no binding, got %+v", binding)
+				}
+				return
+			}
+			if diff := cmp.Diff(tc.actions, binding.Spec.ValidationActions); diff != "" {
+				t.Errorf("unexpected validation actions (-want +got):\n%s", diff)
+			}
+
+			var selector string
+			if s := binding.Spec.MatchResources.NamespaceSelector; s != nil {
+				selector = labelSelectorString(t, s)
+			}
+			if selector != tc.selector {
+				t.Errorf("unexpected namespace selector. expected %q, actual %q", tc.selector, selector)
+			}
+
+			operations := tc.operations
+			if operations == nil {
+				operations = []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update}
+			}
+			for _, rule := range binding.Spec.MatchResources.ResourceRules {
+				if diff := cmp.Diff(operations, rule.Operations); diff != "" {
+					t.Errorf("unexpected operations (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+// parsePolicy returns a policy with the metadata annotations.
+func parsePolicy(t *testing.T, metadata string) rego.Rego {
+	t.Helper()
+
+	var header strings.Builder
+	header.WriteString("# METADATA\n")
+	for _, line := range strings.Split(metadata, "\n") {
+		header.WriteString("# " + line + "\n")
+	}
+
+	dir := filepath.Join(t.TempDir(), "policy")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	source := header.String() + "package policy\n\nviolation[msg] {\n  msg := \"foo\"\n}\n"
+	if err := os.WriteFile(filepath.Join(dir, "src.rego"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	violations, err := rego.GetViolations(dir)
+	if err != nil {
+		t.Fatalf("get violations: %s", err)
+	}
+
+	return violations[0]
+}
+
+func labelSelectorString(t *testing.T, selector *metav1.LabelSelector) string {
+	t.Helper()
+
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		t.Fatalf("convert selector: %s", err)
+	}
+
+	return s.String()
+}
@@ -0,0 +1,318 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/plexsystems/konstraint/internal/gatekeeper"
+	"github.com/plexsystems/konstraint/internal/rego"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newVerifyCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "verify <dir>",
+		Short: "Verify the generated Gatekeeper resources against test fixtures",
+		Long: `Verify renders the ConstraintTemplate and Constraints of every policy that declares
+tests in its metadata, and evaluates them against the fixtures with the Gatekeeper
+constraint framework. The resources in the allowed fixtures must not cause a violation,
+while every resource in the disallowed fixtures must cause at least one violation.
+
+Resources are reviewed as if they were created. A fixture can also be an AdmissionReview,
+whose request is reviewed with its operation, oldObject and userInfo.`,
+		Example: `Verify the policies in the examples directory
+	konstraint verify examples`,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := "."
+			if len(args) > 0 {
+				path = args[0]
+			}
+
+			// A failing policy is not a usage error.
+			cmd.SilenceUsage = true
+
+			return runVerifyCommand(path, os.Stdout)
+		},
+	}
+
+	return &cmd
+}
+
+func runVerifyCommand(path string, out io.Writer) error {
+	violations, err := rego.GetViolations(path)
+	if err != nil {
+		return fmt.Errorf("get violations: %w", err)
+	}
+
+	var verified, failed, skipped int
+	for _, violation := range violations {
+		logger := log.WithFields(log.Fields{
+			"name": violation.Kind(),
+			"src":  violation.Path(),
+		})
+
+		tests := violation.AnnotationTests()
+		if len(tests.Allowed) == 0 && len(tests.Disallowed) == 0 {
+			logger.Debug("Skipping policy without tests")
+			continue
+		}
+
+		// The fixtures of a policy that cannot be evaluated are never run, which
+		// fails verification rather than passing it silently.
+		if unset := getParametersWithoutDefault(violation); len(unset) > 0 {
+			skipped++
+			fmt.Fprintf(out, "SKIP %s (%s)\n", violation.Kind(), violation.Path())
+			fmt.Fprintf(out, "  parameters without a default and without custom.constraints: %s\n", strings.Join(unset, ", "))
+			continue
+		}
+
+		failures, err := verifyPolicy(violation, logger)
+		if err != nil {
+			return fmt.Errorf("verify policy %s: %w", violation.Path(), err)
+		}
+
+		verified++
+		if len(failures) == 0 {
+			fmt.Fprintf(out, "PASS %s (%s)\n", violation.Kind(), violation.Path())
+			continue
+		}
+
+		failed++
+		fmt.Fprintf(out, "FAIL %s (%s)\n", violation.Kind(), violation.Path())
+		for _, failure := range failures {
+			fmt.Fprintf(out, "  %s\n", failure)
+		}
+	}
+
+	if verified == 0 && skipped == 0 {
+		log.Warn("No policies with tests found")
+	}
+
+	var problems []string
+	if failed > 0 {
+		problems = append(problems, fmt.Sprintf("%d of %d policies failed verification", failed, verified))
+	}
+	if skipped > 0 {
+		problems = append(problems, fmt.Sprintf("%d policies with tests could not be verified", skipped))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, ", "))
+	}
+
+	log.WithField("num_policies", verified).Info("completed successfully")
+	return nil
+}
+
+// verifyPolicy evaluates the fixtures of the policy against its rendered
+// ConstraintTemplate and Constraints, and returns a description of every
+// resource that did not have the expected outcome.
+func verifyPolicy(violation rego.Rego, logger *log.Entry) ([]string, error) {
+	ctx := context.Background()
+
+	client, err := gatekeeper.NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("new client: %w", err)
+	}
+
+	if _, err := addPolicy(ctx, client, violation, logger); err != nil {
+		return nil, err
+	}
+
+	tests := violation.AnnotationTests()
+	allowed, err := readFixtures(violation, tests.Allowed)
+	if err != nil {
+		return nil, fmt.Errorf("read allowed fixtures: %w", err)
+	}
+	disallowed, err := readFixtures(violation, tests.Disallowed)
+	if err != nil {
+		return nil, fmt.Errorf("read disallowed fixtures: %w", err)
+	}
+
+	// Namespaces in the fixtures are used to evaluate namespaceSelectors.
+	namespaces := getNamespaces(append(allowed, disallowed...))
+
+	var failures []string
+	for _, fixtures := range []struct {
+		fixtures []fixture
+		allowed  bool
+	}{{allowed, true}, {disallowed, false}} {
+		for _, fixture := range fixtures.fixtures {
+			// Gatekeeper does not evaluate the policy for other operations, so
+			// the fixture would not test it.
+			object := fixture.review(namespaces)
+			if !gatekeeper.HandlesOperation(violation.AnnotationOperations(), object.Operation()) {
+				failures = append(failures, fmt.Sprintf("%s: the %s operation is not one of the operations of the policy, set the operation with an AdmissionReview", fixture, object.Operation()))
+				continue
+			}
+
+			resp, err := client.Review(ctx, object)
+			if err != nil {
+				return nil, fmt.Errorf("review %s: %w", fixture, err)
+			}
+
+			results := resp.Results()
+			logger.WithField("resource", fixture.String()).Debugf("Found %d violation(s)", len(results))
+
+			if fixtures.allowed {
+				for _, result := range results {
+					failures = append(failures, fmt.Sprintf("%s: unexpected violation: %s", fixture, result.Msg))
+				}
+			} else if len(results) == 0 {
+				failures = append(failures, fmt.Sprintf("%s: expected a violation, got none", fixture))
+			}
+		}
+	}
+
+	return failures, nil
+}
+
+// getParametersWithoutDefault returns the parameters of a policy without
+// Constraints in custom.constraints that have no default in their schema. The
+// Constraint of such a policy is evaluated with the defaults of its parameters,
+// so it cannot be evaluated while any of them has no default, as rules that
+// read them would never match.
+func getParametersWithoutDefault(violation rego.Rego) []string {
+	if len(violation.AnnotationConstraints()) > 0 {
+		return nil
+	}
+
+	var unset []string
+	for name, schema := range violation.AnnotationParameters() {
+		if schema.Default == nil {
+			unset = append(unset, name)
+		}
+	}
+	sort.Strings(unset)
+
+	return unset
+}
+
+// addPolicy adds the rendered ConstraintTemplate and Constraints of the policy
+// to the client, and returns the policy of every Constraint.
+func addPolicy(ctx context.Context, client *client.Client, violation rego.Rego, logger *log.Entry) ([]rego.Rego, error) {
+	templateBytes, err := renderConstraintTemplate(violation, "v1", "", logger)
+	if err != nil {
+		return nil, fmt.Errorf("rendering ConstraintTemplate: %w", err)
+	}
+	template, err := gatekeeper.ToTemplate(templateBytes)
+	if err != nil {
+		return nil, fmt.Errorf("read ConstraintTemplate: %w", err)
+	}
+	if _, err := client.AddTemplate(ctx, template); err != nil {
+		return nil, fmt.Errorf("add ConstraintTemplate: %w", err)
+	}
+
+	instances := []rego.Rego{violation}
+	if len(violation.AnnotationConstraints()) > 0 {
+		instances = nil
+		for _, c := range violation.AnnotationConstraints() {
+			instance, err := violation.ForConstraint(c)
+			if err != nil {
+				return nil, fmt.Errorf("get constraint %s: %w", c.Name, err)
+			}
+			instances = append(instances, instance)
+		}
+	}
+
+	for _, instance := range instances {
+		constraintBytes, err := renderConstraint(instance, "", logger, nil)
+		if err != nil {
+			return nil, fmt.Errorf("rendering Constraint: %w", err)
+		}
+		constraint, err := gatekeeper.ToUnstructured(constraintBytes)
+		if err != nil {
+			return nil, fmt.Errorf("read Constraint: %w", err)
+		}
+		if instance.ConstraintParameters() == nil && len(instance.AnnotationParameters()) > 0 {
+			if err := addParametersToConstraint(constraint, instance.AnnotationParameters()); err != nil {
+				return nil, fmt.Errorf("add default parameters: %w", err)
+			}
+		}
+		if _, err := client.AddConstraint(ctx, constraint); err != nil {
+			return nil, fmt.Errorf("add Constraint %s: %w", constraint.GetName(), err)
+		}
+	}
+
+	return instances, nil
+}
+
+// fixture is a resource read from one of the test fixtures of a policy. For
+// an AdmissionReview, it is the resource of its request, which the resource
+// is reviewed with.
+type fixture struct {
+	path    string
+	object  *unstructured.Unstructured
+	request *admissionv1.AdmissionRequest
+}
+
+// newFixture returns the fixture of a resource read from the file.
+func newFixture(path string, object *unstructured.Unstructured) (fixture, error) {
+	if !gatekeeper.IsAdmissionReview(object) {
+		return fixture{path: path, object: object}, nil
+	}
+
+	o, err := gatekeeper.FromAdmissionReview(object)
+	if err != nil {
+		return fixture{}, fmt.Errorf("read AdmissionReview in %s: %w", path, err)
+	}
+
+	return fixture{path: path, object: o.Object, request: o.Request}, nil
+}
+
+// review returns the object to review the fixture with, along with its
+// Namespace from the namespaces.
+func (f fixture) review(namespaces map[string]*unstructured.Unstructured) gatekeeper.Object {
+	return gatekeeper.Object{
+		Object:    f.object,
+		Namespace: namespaces[f.object.GetNamespace()],
+		Request:   f.request,
+	}
+}
+
+func (f fixture) String() string {
+	name := f.object.GetName()
+	if f.object.GetNamespace() != "" {
+		name = f.object.GetNamespace() + "/" + name
+	}
+
+	if f.request != nil {
+		return fmt.Sprintf("%s: %s %s %s", f.path, f.request.Operation, f.object.GetKind(), name)
+	}
+	return fmt.Sprintf("%s: %s %s", f.path, f.object.GetKind(), name)
+}
+
+func readFixtures(violation rego.Rego, paths []string) ([]fixture, error) {
+	var fixtures []fixture
+	for _, path := range paths {
+		path = filepath.Join(filepath.Dir(violation.Path()), path)
+
+		objects, err := gatekeeper.ReadObjects(path)
+		if err != nil {
+			return nil, fmt.Errorf("read objects: %w", err)
+		}
+		if len(objects) == 0 {
+			return nil, fmt.Errorf("no resources found in %s", path)
+		}
+
+		for _, object := range objects {
+			f, err := newFixture(path, object)
+			if err != nil {
+				return nil, err
+			}
+			fixtures = append(fixtures, f)
+		}
+	}
+
+	return fixtures, nil
+}
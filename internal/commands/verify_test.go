@@ -0,0 +1,174 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunVerifyCommand(t *testing.T) {
+	var out bytes.Buffer
+	if err := runVerifyCommand("../../examples", &out); err != nil {
+		t.Fatalf("verify examples: %s\n%s", err, out.String())
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if !strings.HasPrefix(line, "PASS ") {
+			t.Errorf("unexpected output: %s", line)
+		}
+	}
+}
+
+func TestRunVerifyCommandParameterDefaults(t *testing.T) {
+	const policy = `# METADATA
+# title: Required labels
+# custom:
+#   parameters:
+#     labels:
+#       type: array
+#       items:
+#         type: string
+%s
+#   tests:
+#     disallowed: [disallowed.yaml]
+package required_labels
+
+import future.keywords.in
+
+violation[{"msg": msg}] {
+	some label in input.parameters.labels
+	not input.review.object.metadata.labels[label]
+	msg := sprintf("has no %%s label", [label])
+}
+`
+
+	testCases := []struct {
+		desc     string
+		schema   string
+		expected string
+		err      bool
+	}{
+		{
+			desc:     "Default",
+			schema:   "#       default: [team]",
+			expected: "PASS RequiredLabels",
+		},
+		{
+			// Without parameters, the disallowed fixture would be allowed, so
+			// the policy cannot be verified.
+			desc:     "No default",
+			schema:   "#",
+			expected: "SKIP RequiredLabels",
+			err:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "required_labels")
+			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "src.rego"), []byte(fmt.Sprintf(policy, tc.schema)), 0644); err != nil {
+				t.Fatal(err)
+			}
+			fixture := "apiVersion: v1\nkind: Pod\nmetadata:\n  name: unlabeled\n"
+			if err := os.WriteFile(filepath.Join(dir, "disallowed.yaml"), []byte(fixture), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			var out bytes.Buffer
+			err := runVerifyCommand(dir, &out)
+			if tc.err != (err != nil) {
+				t.Errorf("unexpected error: %v\n%s", err, out.String())
+			}
+			if !strings.HasPrefix(out.String(), tc.expected) {
+				t.Errorf("expected output to start with %q, got %q", tc.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestRunVerifyCommandAdmissionReview(t *testing.T) {
+	const policy = `# METADATA
+# title: Protected Pods
+# custom:
+#   operations: [DELETE]
+#   tests:
+#     allowed: [allowed.yaml]
+#     disallowed: [disallowed.yaml]
+package pod_deny_delete_protected
+
+violation[{"msg": msg}] {
+	input.review.oldObject.metadata.labels.protected == "true"
+	input.review.userInfo.username != "admin"
+	msg := sprintf("%s cannot delete a protected Pod", [input.review.userInfo.username])
+}
+`
+	const review = `apiVersion: admission.k8s.io/v1
+kind: AdmissionReview
+request:
+  operation: DELETE
+  userInfo:
+    username: %s
+  oldObject:
+    apiVersion: v1
+    kind: Pod
+    metadata:
+      name: frontend
+      labels:
+        protected: "true"
+`
+
+	testCases := []struct {
+		desc     string
+		allowed  string
+		expected string
+		err      bool
+	}{
+		{
+			desc:     "AdmissionReview",
+			allowed:  fmt.Sprintf(review, "admin"),
+			expected: "PASS PodDenyDeleteProtected",
+		},
+		{
+			// A Pod is reviewed as it is created, which the policy does not
+			// handle.
+			desc:     "Other operation",
+			allowed:  "apiVersion: v1\nkind: Pod\nmetadata:\n  name: frontend\n",
+			expected: "the CREATE operation is not one of the operations of the policy",
+			err:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "pod_deny_delete_protected")
+			files := map[string]string{
+				"src.rego":        policy,
+				"allowed.yaml":    tc.allowed,
+				"disallowed.yaml": fmt.Sprintf(review, "alice"),
+			}
+			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var out bytes.Buffer
+			err := runVerifyCommand(dir, &out)
+			if tc.err != (err != nil) {
+				t.Errorf("unexpected error: %v\n%s", err, out.String())
+			}
+			if !strings.Contains(out.String(), tc.expected) {
+				t.Errorf("expected output to contain %q, got %q", tc.expected, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+// Package diagnostic describes problems found in policies, and writes them in
+// formats that CI systems can point reviewers at.
+package diagnostic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// Severity is the severity of a diagnostic.
+type Severity string
+
+// The severities of diagnostics. Only errors fail a command.
+const (
+	Error   Severity = "error"
+	Warning Severity = "warning"
+)
+
+// Codes of the diagnostics. They are stable, so that they can be used to
+// filter or suppress diagnostics in CI systems.
+const (
+	CodeRegoParse            = "rego-parse"
+	CodeRegoCompile          = "rego-compile"
+	CodeInvalidImport        = "invalid-import"
+	CodePackageConflict      = "package-conflict"
+	CodeAnnotationConflict   = "annotation-conflict"
+	CodeInvalidAnnotation    = "invalid-annotation"
+	CodeInvalidCEL           = "invalid-cel"
+	CodeUndeclaredParameter  = "undeclared-parameter"
+	CodeInvalidEnforcement   = "invalid-enforcement"
+	CodeResourceConflict     = "resource-conflict"
+	CodeRenderFailed         = "render-failed"
+	CodeMissingTitle         = "missing-title"
+	CodeMissingKindMatchers  = "missing-kind-matchers"
+	CodeUnsetParameter       = "unset-parameter"
+	CodeUnusedParameter      = "unused-parameter"
+	CodeUndeterminedSyncData = "undetermined-sync-data"
+	CodeMissingCEL           = "missing-cel"
+)
+
+// Diagnostic is a problem found in a policy, at a location in its source.
+type Diagnostic struct {
+	Code     string   `json:"code"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line,omitempty"`
+	Column   int      `json:"column,omitempty"`
+}
+
+// Errorf returns an error diagnostic at the given location, which may be nil.
+func Errorf(code string, location *ast.Location, format string, a ...any) Diagnostic {
+	return New(code, Error, location, fmt.Sprintf(format, a...))
+}
+
+// Warningf returns a warning diagnostic at the given location, which may be
+// nil.
+func Warningf(code string, location *ast.Location, format string, a ...any) Diagnostic {
+	return New(code, Warning, location, fmt.Sprintf(format, a...))
+}
+
+// New returns a diagnostic at the given location, which may be nil.
+func New(code string, severity Severity, location *ast.Location, message string) Diagnostic {
+	d := Diagnostic{Code: code, Severity: severity, Message: message}
+	if location != nil {
+		d.File = location.File
+		d.Line = location.Row
+		d.Column = location.Col
+	}
+
+	return d
+}
+
+// Location returns the location of the diagnostic as file:line:column, or
+// the file when the line is unknown. It is empty when the file is unknown.
+func (d Diagnostic) Location() string {
+	if d.Line > 0 {
+		return fmt.Sprintf("%s:%d:%d", d.File, d.Line, d.Column)
+	}
+
+	return d.File
+}
+
+func (d Diagnostic) String() string {
+	var location string
+	if l := d.Location(); l != "" {
+		location = l + ": "
+	}
+
+	return fmt.Sprintf("%s%s: %s (%s)", location, d.Severity, d.Message, d.Code)
+}
+
+// List is a list of diagnostics. It is returned as an error when it
+// contains errors.
+type List []Diagnostic
+
+// Add adds the diagnostic to the list. Adding to a nil list is a no-op, so
+// that the diagnostics can be ignored by passing nil.
+func (l *List) Add(d Diagnostic) {
+	if l == nil {
+		return
+	}
+
+	*l = append(*l, d)
+}
+
+// Errors returns the number of error diagnostics in the list.
+func (l List) Errors() int {
+	var n int
+	for _, d := range l {
+		if d.Severity == Error {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Err returns the list as an error when it contains errors, or nil.
+func (l List) Err() error {
+	if l.Errors() == 0 {
+		return nil
+	}
+
+	return l
+}
+
+// Error lists the error diagnostics of the list. The warnings are left out,
+// as they are reported separately and do not fail a command.
+func (l List) Error() string {
+	var lines []string
+	for _, d := range l {
+		if d.Severity == Error {
+			lines = append(lines, d.String())
+		}
+	}
+
+	return fmt.Sprintf("found %d error(s):\n  %s", len(lines), strings.Join(lines, "\n  "))
+}
+
+// Sort sorts the diagnostics by their location.
+func (l List) Sort() {
+	sort.SliceStable(l, func(i, j int) bool {
+		if l[i].File != l[j].File {
+			return l[i].File < l[j].File
+		}
+		if l[i].Line != l[j].Line {
+			return l[i].Line < l[j].Line
+		}
+		return l[i].Column < l[j].Column
+	})
+}
+
+// FromError converts an error into diagnostics. The diagnostics in the error
+// are returned as they are, and errors of the OPA parser or compiler are
+// converted with their own locations. Any other error is returned as a
+// single diagnostic with the given code and location.
+func FromError(code string, location *ast.Location, err error) List {
+	var list List
+	if errors.As(err, &list) {
+		return list
+	}
+
+	var astErrors ast.Errors
+	if errors.As(err, &astErrors) {
+		for _, e := range astErrors {
+			list = append(list, Errorf(code, e.Location, "%s", e.Message))
+		}
+		return list
+	}
+
+	var astError *ast.Error
+	if errors.As(err, &astError) {
+		return List{Errorf(code, astError.Location, "%s", astError.Message)}
+	}
+
+	return List{Errorf(code, location, "%s", err)}
+}
@@ -0,0 +1,15 @@
+package diagnostic
+
+import "testing"
+
+func TestListError(t *testing.T) {
+	const expected = `found 2 error(s):
+  policy/src.rego:3:7: error: unexpected eof token (rego-parse)
+  error: two
+lines (resource-conflict)`
+
+	actual := testDiagnostics.Error()
+	if actual != expected {
+		t.Errorf("unexpected error. expected %q, actual %q", expected, actual)
+	}
+}
@@ -0,0 +1,189 @@
+package diagnostic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// The formats that diagnostics can be written in.
+const (
+	FormatJSON   = "json"
+	FormatSARIF  = "sarif"
+	FormatGitHub = "github"
+)
+
+// Formats are all formats that diagnostics can be written in.
+var Formats = []string{FormatJSON, FormatSARIF, FormatGitHub}
+
+// Write writes the diagnostics to out in the given format.
+func Write(out io.Writer, format string, list List) error {
+	switch format {
+	case FormatJSON:
+		return writeJSON(out, list)
+	case FormatSARIF:
+		return writeSARIF(out, list)
+	case FormatGitHub:
+		return writeGitHub(out, list)
+	default:
+		return fmt.Errorf("unknown diagnostics format %q, must be one of %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+func writeJSON(out io.Writer, list List) error {
+	if list == nil {
+		list = List{}
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(list); err != nil {
+		return fmt.Errorf("encode diagnostics: %w", err)
+	}
+
+	return nil
+}
+
+// writeGitHub writes the diagnostics as workflow commands, which GitHub
+// Actions shows as annotations on the lines of the pull request.
+func writeGitHub(out io.Writer, list List) error {
+	for _, d := range list {
+		command := "warning"
+		if d.Severity == Error {
+			command = "error"
+		}
+
+		var properties []string
+		if d.File != "" {
+			properties = append(properties, "file="+escapeGitHubProperty(filepath.ToSlash(d.File)))
+		}
+		if d.Line > 0 {
+			properties = append(properties, fmt.Sprintf("line=%d", d.Line))
+		}
+		if d.Column > 0 {
+			properties = append(properties, fmt.Sprintf("col=%d", d.Column))
+		}
+		properties = append(properties, "title="+escapeGitHubProperty(d.Code))
+
+		if _, err := fmt.Fprintf(out, "::%s %s::%s\n", command, strings.Join(properties, ","), escapeGitHubData(d.Message)); err != nil {
+			return fmt.Errorf("write diagnostic: %w", err)
+		}
+	}
+
+	return nil
+}
+
+var githubDataReplacer = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+
+var githubPropertyReplacer = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+
+func escapeGitHubData(s string) string {
+	return githubDataReplacer.Replace(s)
+}
+
+func escapeGitHubProperty(s string) string {
+	return githubPropertyReplacer.Replace(s)
+}
+
+// The subset of SARIF 2.1.0 that is needed to report diagnostics.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+func writeSARIF(out io.Writer, list List) error {
+	driver := sarifDriver{
+		Name:           "konstraint",
+		InformationURI: "https://github.com/plexsystems/konstraint",
+		Rules:          []sarifRule{},
+	}
+
+	seen := make(map[string]bool)
+	results := []sarifResult{}
+	for _, d := range list {
+		if !seen[d.Code] {
+			seen[d.Code] = true
+			driver.Rules = append(driver.Rules, sarifRule{ID: d.Code})
+		}
+
+		result := sarifResult{
+			RuleID:  d.Code,
+			Level:   string(d.Severity),
+			Message: sarifMessage{Text: d.Message},
+		}
+		if d.File != "" {
+			location := sarifLocation{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(d.File)},
+			}}
+			if d.Line > 0 {
+				location.PhysicalLocation.Region = &sarifRegion{StartLine: d.Line, StartColumn: d.Column}
+			}
+			result.Locations = []sarifLocation{location}
+		}
+		results = append(results, result)
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}},
+	})
+	if err != nil {
+		return fmt.Errorf("encode diagnostics: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package diagnostic
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+var testDiagnostics = List{
+	{Code: CodeRegoParse, Severity: Error, Message: "unexpected eof token", File: "policy/src.rego", Line: 3, Column: 7},
+	{Code: CodeMissingTitle, Severity: Warning, Message: "No title set: 100%, really", File: "policy, other/src.rego", Line: 1, Column: 1},
+	{Code: CodeResourceConflict, Severity: Error, Message: "two\nlines"},
+}
+
+func TestWriteGitHub(t *testing.T) {
+	const expected = `::error file=policy/src.rego,line=3,col=7,title=rego-parse::unexpected eof token
+::warning file=policy%2C other/src.rego,line=1,col=1,title=missing-title::No title set: 100%25, really
+::error title=resource-conflict::two%0Alines
+`
+
+	var out bytes.Buffer
+	if err := Write(&out, FormatGitHub, testDiagnostics); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expected {
+		t.Errorf("unexpected output. expected %q, actual %q", expected, out.String())
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	testCases := []struct {
+		desc string
+		list List
+	}{
+		{desc: "Diagnostics", list: testDiagnostics},
+		{desc: "No diagnostics", list: nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Write(&out, FormatJSON, tc.list); err != nil {
+				t.Fatal(err)
+			}
+
+			actual := List{}
+			if err := json.Unmarshal(out.Bytes(), &actual); err != nil {
+				t.Fatalf("unmarshal output %s: %s", out.String(), err)
+			}
+			if len(actual) != len(tc.list) {
+				t.Fatalf("unexpected number of diagnostics. expected %d, actual %d", len(tc.list), len(actual))
+			}
+			for i := range actual {
+				if actual[i] != tc.list[i] {
+					t.Errorf("unexpected diagnostic. expected %v, actual %v", tc.list[i], actual[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	var out bytes.Buffer
+	if err := Write(&out, FormatSARIF, testDiagnostics); err != nil {
+		t.Fatal(err)
+	}
+
+	var actual sarifLog
+	if err := json.Unmarshal(out.Bytes(), &actual); err != nil {
+		t.Fatalf("unmarshal output %s: %s", out.String(), err)
+	}
+
+	if actual.Version != "2.1.0" || len(actual.Runs) != 1 {
+		t.Fatalf("unexpected SARIF log: %s", out.String())
+	}
+	run := actual.Runs[0]
+	if len(run.Tool.Driver.Rules) != 3 {
+		t.Errorf("unexpected rules. expected 3, actual %v", run.Tool.Driver.Rules)
+	}
+	if len(run.Results) != len(testDiagnostics) {
+		t.Fatalf("unexpected results. expected %d, actual %d", len(testDiagnostics), len(run.Results))
+	}
+
+	first := run.Results[0]
+	if first.RuleID != CodeRegoParse || first.Level != "error" || first.Locations[0].PhysicalLocation.Region.StartLine != 3 {
+		t.Errorf("unexpected result: %+v", first)
+	}
+	if last := run.Results[2]; len(last.Locations) != 0 {
+		t.Errorf("expected no locations for a diagnostic without a file, actual %+v", last.Locations)
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	var out bytes.Buffer
+	if err := Write(&out, "xml", testDiagnostics); err == nil {
+		t.Error("expected error, got none")
+	}
+}
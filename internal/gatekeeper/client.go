@@ -0,0 +1,123 @@
+// Package gatekeeper evaluates generated ConstraintTemplates and Constraints
+// offline, using the constraint framework of Gatekeeper.
+package gatekeeper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/plexsystems/konstraint/internal/rego"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/apis"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	regodriver "github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/rego"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	if err := apis.AddToScheme(scheme); err != nil {
+		panic(fmt.Sprintf("add Gatekeeper APIs to scheme: %s", err))
+	}
+}
+
+// NewClient returns a client of the constraint framework that evaluates
+// ConstraintTemplates with the Rego driver, for all enforcement points.
+func NewClient() (*client.Client, error) {
+	driver, err := regodriver.New()
+	if err != nil {
+		return nil, fmt.Errorf("new rego driver: %w", err)
+	}
+
+	c, err := client.NewClient(
+		client.Targets(target{}),
+		client.Driver(driver),
+		client.EnforcementPoints(rego.EnforcementPoints...),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("new client: %w", err)
+	}
+
+	return c, nil
+}
+
+// ToTemplate converts a rendered ConstraintTemplate of any version into the
+// ConstraintTemplate used by the client.
+func ToTemplate(content []byte) (*templates.ConstraintTemplate, error) {
+	u, err := ToUnstructured(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var template templates.ConstraintTemplate
+	if err := scheme.Convert(u, &template, nil); err != nil {
+		return nil, fmt.Errorf("convert ConstraintTemplate: %w", err)
+	}
+
+	return &template, nil
+}
+
+// ToUnstructured converts a single rendered resource into an unstructured
+// object.
+func ToUnstructured(content []byte) (*unstructured.Unstructured, error) {
+	var u unstructured.Unstructured
+	if err := yaml.Unmarshal(content, &u.Object); err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	return &u, nil
+}
+
+// ReadObjects reads all resources from a YAML file with one or more
+// documents, or from a JSON file. The items of lists are read as resources.
+func ReadObjects(path string) ([]*unstructured.Unstructured, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+
+	var objects []*unstructured.Unstructured
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(content), 4096)
+	for {
+		var object map[string]any
+		if err := decoder.Decode(&object); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("decode %s: %w", path, err)
+		}
+		if len(object) == 0 {
+			continue
+		}
+
+		u := &unstructured.Unstructured{Object: object}
+		if u.GetKind() == "" {
+			return nil, fmt.Errorf("resource in %s has no kind", path)
+		}
+
+		// Lists, such as the output of kubectl get -o yaml, are read as the
+		// resources that they contain.
+		if u.IsList() {
+			err := u.EachListItem(func(item runtime.Object) error {
+				objects = append(objects, item.(*unstructured.Unstructured))
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("read list in %s: %w", path, err)
+			}
+			continue
+		}
+
+		objects = append(objects, u)
+	}
+
+	return objects, nil
+}
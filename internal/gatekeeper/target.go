@@ -0,0 +1,459 @@
+package gatekeeper
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/plexsystems/konstraint/internal/rego"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/constraints"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/handler"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// TargetName is the name of the Gatekeeper target for admission requests,
+// which is the target of all ConstraintTemplates generated by Konstraint.
+const TargetName = "admission.k8s.gatekeeper.sh"
+
+// Object is a resource to review, along with the Namespace it is created in.
+// The Namespace is only needed when a Constraint uses a namespaceSelector.
+type Object struct {
+	Object    *unstructured.Unstructured
+	Namespace *unstructured.Unstructured
+
+	// Request is the admission request the resource is reviewed with, such as
+	// the request of an AdmissionReview. Without a request, the resource is
+	// reviewed as it is created.
+	Request *admissionv1.AdmissionRequest
+}
+
+// Operation returns the admission operation the object is reviewed with.
+func (o Object) Operation() admissionv1.Operation {
+	if o.Request == nil {
+		return admissionv1.Create
+	}
+
+	return o.Request.Operation
+}
+
+// review is the review of a resource as the Gatekeeper webhook passes it to
+// the policies in input.review: the admission request, along with the
+// Namespace of the resource.
+type review struct {
+	admissionv1.AdmissionRequest
+	Unstable *unstable `json:"_unstable,omitempty"`
+
+	object    *unstructured.Unstructured
+	namespace *unstructured.Unstructured
+}
+
+var _ client.ARGetter = &review{}
+
+// GetAdmissionRequest returns the admission request of the review, which the
+// client uses to only evaluate the ConstraintTemplates for its operation at
+// the validation.gatekeeper.sh enforcement point.
+func (r *review) GetAdmissionRequest() *admissionv1.AdmissionRequest {
+	return &r.AdmissionRequest
+}
+
+type unstable struct {
+	Namespace map[string]any `json:"namespace,omitempty"`
+}
+
+// ReviewInput returns the input of a policy that reviews the object, with
+// the parameters of a Constraint, as Gatekeeper passes it to the policy.
+func ReviewInput(o Object, parameters map[string]any) (map[string]any, error) {
+	rev, err := newReview(o)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(rev)
+	if err != nil {
+		return nil, fmt.Errorf("marshal review: %w", err)
+	}
+
+	var r map[string]any
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("unmarshal review: %w", err)
+	}
+
+	return map[string]any{
+		"review":     r,
+		"parameters": parameters,
+	}, nil
+}
+
+// Matches returns whether a Constraint with the match field applies to the
+// object.
+func Matches(match rego.AnnoMatch, o Object) (bool, error) {
+	rev, err := newReview(o)
+	if err != nil {
+		return false, err
+	}
+
+	return matcher{match: match}.Match(rev)
+}
+
+// HandlesOperation returns whether a ConstraintTemplate with the operations
+// of a policy is evaluated for a review with the operation. Without
+// operations, it is evaluated for all of them.
+func HandlesOperation(operations []string, operation admissionv1.Operation) bool {
+	if len(operations) == 0 {
+		return true
+	}
+
+	return contains(operations, string(operation))
+}
+
+// IsAdmissionReview returns whether the resource is an AdmissionReview, whose
+// request is reviewed instead of the resource itself.
+func IsAdmissionReview(u *unstructured.Unstructured) bool {
+	return u.GetAPIVersion() == admissionv1.SchemeGroupVersion.String() && u.GetKind() == "AdmissionReview"
+}
+
+// FromAdmissionReview returns the object to review from the request of an
+// AdmissionReview. As in the Gatekeeper webhook, the object of a DELETE
+// request is its oldObject.
+func FromAdmissionReview(u *unstructured.Unstructured) (Object, error) {
+	b, err := json.Marshal(u.Object)
+	if err != nil {
+		return Object{}, fmt.Errorf("marshal AdmissionReview: %w", err)
+	}
+
+	var admissionReview admissionv1.AdmissionReview
+	if err := json.Unmarshal(b, &admissionReview); err != nil {
+		return Object{}, fmt.Errorf("unmarshal AdmissionReview: %w", err)
+	}
+
+	request := admissionReview.Request
+	if request == nil {
+		return Object{}, fmt.Errorf("AdmissionReview has no request")
+	}
+
+	raw := request.Object.Raw
+	switch request.Operation {
+	case admissionv1.Create, admissionv1.Update, admissionv1.Connect:
+	case admissionv1.Delete:
+		raw = request.OldObject.Raw
+	default:
+		return Object{}, fmt.Errorf("invalid operation %q, must be one of %s", request.Operation, strings.Join(rego.Operations, ", "))
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return Object{}, fmt.Errorf("%s request has no object to review", request.Operation)
+	}
+
+	var object unstructured.Unstructured
+	if err := object.UnmarshalJSON(raw); err != nil {
+		return Object{}, fmt.Errorf("unmarshal object: %w", err)
+	}
+
+	return Object{Object: &object, Request: request}, nil
+}
+
+// target is a handler for the admission target of Gatekeeper that reviews
+// resources the same way the Gatekeeper webhook does.
+type target struct{}
+
+var _ handler.TargetHandler = target{}
+
+func (target) GetName() string {
+	return TargetName
+}
+
+// MatchSchema accepts any match field, the matchers are already validated
+// when the policies are parsed.
+func (target) MatchSchema() apiextensions.JSONSchemaProps {
+	preserve := true
+	return apiextensions.JSONSchemaProps{XPreserveUnknownFields: &preserve}
+}
+
+// ProcessData returns the path of a resource in data.inventory, which is the
+// same as in Gatekeeper: cluster/<groupVersion>/<kind>/<name> for cluster
+// scoped resources, and namespace/<namespace>/<groupVersion>/<kind>/<name>
+// for namespaced resources.
+func (target) ProcessData(obj any) (bool, []string, any, error) {
+	o, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return false, nil, nil, nil
+	}
+
+	gvk := o.GroupVersionKind()
+	if gvk.Version == "" {
+		return true, nil, nil, fmt.Errorf("resource %s has no version", o.GetName())
+	}
+	if gvk.Kind == "" {
+		return true, nil, nil, fmt.Errorf("resource %s has no kind", o.GetName())
+	}
+
+	var path []string
+	if namespace := o.GetNamespace(); namespace == "" {
+		path = []string{"cluster", gvk.GroupVersion().String(), gvk.Kind, o.GetName()}
+	} else {
+		path = []string{"namespace", namespace, gvk.GroupVersion().String(), gvk.Kind, o.GetName()}
+	}
+
+	return true, path, o.Object, nil
+}
+
+// Inventory returns the data.inventory of Gatekeeper when the objects are
+// synced into it, for evaluating policies without Gatekeeper.
+func Inventory(objects []*unstructured.Unstructured) (map[string]any, error) {
+	inventory := make(map[string]any)
+	for _, object := range objects {
+		_, path, data, err := target{}.ProcessData(object)
+		if err != nil {
+			return nil, err
+		}
+
+		parent := inventory
+		for _, key := range path[:len(path)-1] {
+			child, ok := parent[key].(map[string]any)
+			if !ok {
+				child = make(map[string]any)
+				parent[key] = child
+			}
+			parent = child
+		}
+		parent[path[len(path)-1]] = data
+	}
+
+	return inventory, nil
+}
+
+// newReview returns the review of the object by the Gatekeeper webhook, with
+// the admission request of the object. The fields that the request does not
+// set are taken from the object, and an object without a request is reviewed
+// as it is created.
+func newReview(o Object) (*review, error) {
+	request := admissionv1.AdmissionRequest{Operation: admissionv1.Create}
+	if o.Request != nil {
+		request = *o.Request
+	}
+
+	if request.UID == "" {
+		request.UID = uuid.NewUUID()
+	}
+	if request.Kind.Kind == "" {
+		gvk := o.Object.GroupVersionKind()
+		request.Kind = metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind}
+	}
+	if request.Name == "" {
+		request.Name = o.Object.GetName()
+	}
+	if request.Namespace == "" {
+		request.Namespace = o.Object.GetNamespace()
+	}
+
+	raw, err := o.Object.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("marshal object: %w", err)
+	}
+	request.Object = runtime.RawExtension{Raw: raw}
+
+	r := &review{
+		AdmissionRequest: request,
+		object:           o.Object,
+		namespace:        o.Namespace,
+	}
+	if o.Namespace != nil {
+		r.Unstable = &unstable{Namespace: o.Namespace.Object}
+	}
+
+	return r, nil
+}
+
+func (target) HandleReview(obj any) (bool, any, error) {
+	var o Object
+	switch v := obj.(type) {
+	case Object:
+		o = v
+	case *Object:
+		o = *v
+	case *unstructured.Unstructured:
+		o = Object{Object: v}
+	default:
+		return false, nil, nil
+	}
+
+	rev, err := newReview(o)
+	if err != nil {
+		return true, nil, err
+	}
+
+	return true, rev, nil
+}
+
+func (target) ValidateConstraint(_ *unstructured.Unstructured) error {
+	return nil
+}
+
+func (target) ToMatcher(constraint *unstructured.Unstructured) (constraints.Matcher, error) {
+	match, _, err := unstructured.NestedFieldNoCopy(constraint.Object, "spec", "match")
+	if err != nil {
+		return nil, fmt.Errorf("get match: %w", err)
+	}
+
+	var m matcher
+	if match != nil {
+		b, err := json.Marshal(match)
+		if err != nil {
+			return nil, fmt.Errorf("marshal match: %w", err)
+		}
+		if err := json.Unmarshal(b, &m.match); err != nil {
+			return nil, fmt.Errorf("unmarshal match: %w", err)
+		}
+	}
+
+	return m, nil
+}
+
+// matcher decides whether a Constraint applies to a resource, following the
+// semantics of the match field of Gatekeeper.
+type matcher struct {
+	match rego.AnnoMatch
+}
+
+func (m matcher) Match(r any) (bool, error) {
+	rev, ok := r.(*review)
+	if !ok {
+		return false, fmt.Errorf("unexpected review type %T", r)
+	}
+
+	// The resources are reviewed as they are sent to the webhook, rather than
+	// generated from the expansion of other resources.
+	if m.match.Source == "Generated" {
+		return false, nil
+	}
+
+	obj := rev.object
+	gvk := obj.GroupVersionKind()
+	isNamespace := gvk.Group == "" && gvk.Kind == "Namespace"
+
+	if !matchesKinds(m.match.Kinds, gvk.Group, gvk.Kind) {
+		return false, nil
+	}
+
+	switch m.match.Scope {
+	case "Cluster":
+		if obj.GetNamespace() != "" {
+			return false, nil
+		}
+	case "Namespaced":
+		if obj.GetNamespace() == "" {
+			return false, nil
+		}
+	}
+
+	if m.match.Name != "" && !matchesPattern(m.match.Name, obj.GetName()) {
+		return false, nil
+	}
+
+	selector, err := toSelector(m.match.LabelSelector)
+	if err != nil {
+		return false, fmt.Errorf("labelSelector: %w", err)
+	}
+	if !selector.Matches(labels.Set(obj.GetLabels())) {
+		return false, nil
+	}
+
+	// The namespace matchers do not apply to cluster scoped resources, other
+	// than to Namespaces themselves.
+	namespace := obj.GetNamespace()
+	if isNamespace {
+		namespace = obj.GetName()
+	}
+	if namespace == "" {
+		return true, nil
+	}
+
+	if len(m.match.Namespaces) > 0 && !matchesAnyPattern(m.match.Namespaces, namespace) {
+		return false, nil
+	}
+	if matchesAnyPattern(m.match.ExcludedNamespaces, namespace) {
+		return false, nil
+	}
+
+	if m.match.NamespaceSelector != nil {
+		ns := rev.namespace
+		if isNamespace {
+			ns = obj
+		}
+		if ns == nil {
+			return false, fmt.Errorf("namespace %q is unknown, the namespaceSelector cannot be evaluated", namespace)
+		}
+
+		selector, err := toSelector(m.match.NamespaceSelector)
+		if err != nil {
+			return false, fmt.Errorf("namespaceSelector: %w", err)
+		}
+		if !selector.Matches(labels.Set(ns.GetLabels())) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func matchesKinds(kinds []rego.AnnoKindMatcher, group string, kind string) bool {
+	if len(kinds) == 0 {
+		return true
+	}
+
+	for _, k := range kinds {
+		if contains(k.APIGroups, group) && contains(k.Kinds, kind) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(collection []string, item string) bool {
+	for _, c := range collection {
+		if c == "*" || c == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesAnyPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchesPattern(pattern, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesPattern reports whether the name matches a pattern that may have a
+// wildcard at the start or the end.
+func matchesPattern(pattern string, name string) bool {
+	switch {
+	case strings.HasPrefix(pattern, "*"):
+		return strings.HasSuffix(name, strings.TrimPrefix(pattern, "*"))
+	case strings.HasSuffix(pattern, "*"):
+		return strings.HasPrefix(name, strings.TrimSuffix(pattern, "*"))
+	default:
+		return pattern == name
+	}
+}
+
+func toSelector(selector *metav1.LabelSelector) (labels.Selector, error) {
+	if selector == nil {
+		return labels.Everything(), nil
+	}
+
+	return metav1.LabelSelectorAsSelector(selector)
+}
@@ -0,0 +1,339 @@
+package gatekeeper
+
+import (
+	"testing"
+
+	"github.com/plexsystems/konstraint/internal/rego"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newObject(apiVersion, kind, namespace, name string, labels map[string]string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion(apiVersion)
+	u.SetKind(kind)
+	u.SetNamespace(namespace)
+	u.SetName(name)
+	u.SetLabels(labels)
+	return u
+}
+
+func TestMatch(t *testing.T) {
+	pod := newObject("v1", "Pod", "prod-eu", "frontend-1", map[string]string{"app": "web"})
+	role := newObject("rbac.authorization.k8s.io/v1", "ClusterRole", "", "admin", nil)
+	namespace := newObject("v1", "Namespace", "", "prod-eu", map[string]string{"team": "payments"})
+
+	testCases := []struct {
+		desc      string
+		match     rego.AnnoMatch
+		object    Object
+		want      bool
+		wantError bool
+	}{
+		{
+			desc:   "Empty match",
+			object: Object{Object: pod},
+			want:   true,
+		},
+		{
+			desc:   "Matching kind",
+			match:  rego.AnnoMatch{Kinds: []rego.AnnoKindMatcher{{APIGroups: []string{""}, Kinds: []string{"Pod"}}}},
+			object: Object{Object: pod},
+			want:   true,
+		},
+		{
+			desc:   "Wildcard kind",
+			match:  rego.AnnoMatch{Kinds: []rego.AnnoKindMatcher{{APIGroups: []string{"*"}, Kinds: []string{"*"}}}},
+			object: Object{Object: role},
+			want:   true,
+		},
+		{
+			desc:   "Other kind",
+			match:  rego.AnnoMatch{Kinds: []rego.AnnoKindMatcher{{APIGroups: []string{"apps"}, Kinds: []string{"Deployment"}}}},
+			object: Object{Object: pod},
+		},
+		{
+			desc:   "Cluster scope",
+			match:  rego.AnnoMatch{Scope: "Cluster"},
+			object: Object{Object: pod},
+		},
+		{
+			desc:   "Namespaced scope",
+			match:  rego.AnnoMatch{Scope: "Namespaced"},
+			object: Object{Object: pod},
+			want:   true,
+		},
+		{
+			desc:   "Namespace prefix",
+			match:  rego.AnnoMatch{Namespaces: []string{"prod-*"}},
+			object: Object{Object: pod},
+			want:   true,
+		},
+		{
+			desc:   "Other namespace",
+			match:  rego.AnnoMatch{Namespaces: []string{"dev"}},
+			object: Object{Object: pod},
+		},
+		{
+			desc:   "Namespaces do not apply to cluster scoped resources",
+			match:  rego.AnnoMatch{Namespaces: []string{"dev"}},
+			object: Object{Object: role},
+			want:   true,
+		},
+		{
+			desc:   "Namespaces apply to Namespaces",
+			match:  rego.AnnoMatch{Namespaces: []string{"dev"}},
+			object: Object{Object: namespace},
+		},
+		{
+			desc:   "Excluded namespace suffix",
+			match:  rego.AnnoMatch{ExcludedNamespaces: []string{"*-eu"}},
+			object: Object{Object: pod},
+		},
+		{
+			desc:   "Name prefix",
+			match:  rego.AnnoMatch{Name: "frontend-*"},
+			object: Object{Object: pod},
+			want:   true,
+		},
+		{
+			desc:   "Other name",
+			match:  rego.AnnoMatch{Name: "backend"},
+			object: Object{Object: pod},
+		},
+		{
+			desc: "Matching label selector",
+			match: rego.AnnoMatch{LabelSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"web"}}},
+			}},
+			object: Object{Object: pod},
+			want:   true,
+		},
+		{
+			desc:   "Other label selector",
+			match:  rego.AnnoMatch{LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}},
+			object: Object{Object: pod},
+		},
+		{
+			desc:   "Matching namespace selector",
+			match:  rego.AnnoMatch{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}}},
+			object: Object{Object: pod, Namespace: namespace},
+			want:   true,
+		},
+		{
+			desc:   "Namespace selector on a Namespace",
+			match:  rego.AnnoMatch{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "identity"}}},
+			object: Object{Object: namespace},
+		},
+		{
+			desc:   "Original source",
+			match:  rego.AnnoMatch{Source: "Original"},
+			object: Object{Object: pod},
+			want:   true,
+		},
+		{
+			desc:   "Generated source",
+			match:  rego.AnnoMatch{Source: "Generated"},
+			object: Object{Object: pod},
+		},
+		{
+			desc:      "Namespace selector with unknown namespace",
+			match:     rego.AnnoMatch{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}}},
+			object:    Object{Object: pod},
+			wantError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, r, err := target{}.HandleReview(tc.object)
+			if err != nil {
+				t.Fatalf("handle review: %s", err)
+			}
+
+			got, err := matcher{match: tc.match}.Match(r)
+			if tc.wantError {
+				if err == nil {
+					t.Errorf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tc.want {
+				t.Errorf("match = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReviewInput(t *testing.T) {
+	pod := newObject("v1", "Pod", "prod-eu", "frontend-1", nil)
+	namespace := newObject("v1", "Namespace", "", "prod-eu", map[string]string{"team": "payments"})
+
+	input, err := ReviewInput(Object{Object: pod, Namespace: namespace}, map[string]any{"labels": []any{"team"}})
+	if err != nil {
+		t.Fatalf("review input: %s", err)
+	}
+
+	review, ok := input["review"].(map[string]any)
+	if !ok {
+		t.Fatalf("expected review, got %v", input["review"])
+	}
+	if review["operation"] != "CREATE" || review["namespace"] != "prod-eu" || review["name"] != "frontend-1" || review["uid"] == "" {
+		t.Errorf("unexpected review: %v", review)
+	}
+	if _, ok := review["userInfo"].(map[string]any); !ok {
+		t.Errorf("expected userInfo, got %v", review["userInfo"])
+	}
+	if review["oldObject"] != nil {
+		t.Errorf("expected no oldObject, got %v", review["oldObject"])
+	}
+	name, _, _ := unstructured.NestedString(review, "object", "metadata", "name")
+	if name != "frontend-1" {
+		t.Errorf("expected the object, got %v", review["object"])
+	}
+
+	kind, _, _ := unstructured.NestedString(review, "kind", "kind")
+	if kind != "Pod" {
+		t.Errorf("expected kind Pod, got %q", kind)
+	}
+	team, _, _ := unstructured.NestedString(review, "_unstable", "namespace", "metadata", "labels", "team")
+	if team != "payments" {
+		t.Errorf("expected the labels of the namespace, got %q", team)
+	}
+	if _, ok := input["parameters"].(map[string]any)["labels"]; !ok {
+		t.Errorf("expected parameters, got %v", input["parameters"])
+	}
+}
+
+func TestFromAdmissionReview(t *testing.T) {
+	pod := `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "frontend-1", "namespace": "prod-eu", "labels": {"protected": "true"}}}`
+
+	testCases := []struct {
+		desc      string
+		request   string
+		operation admissionv1.Operation
+		wantError bool
+	}{
+		{
+			desc:      "Update",
+			request:   `{"operation": "UPDATE", "object": ` + pod + `, "oldObject": ` + pod + `, "userInfo": {"username": "alice"}}`,
+			operation: admissionv1.Update,
+		},
+		{
+			desc:      "Delete",
+			request:   `{"operation": "DELETE", "oldObject": ` + pod + `, "userInfo": {"username": "alice"}}`,
+			operation: admissionv1.Delete,
+		},
+		{
+			desc:      "Delete without oldObject",
+			request:   `{"operation": "DELETE", "object": ` + pod + `}`,
+			wantError: true,
+		},
+		{
+			desc:      "Unknown operation",
+			request:   `{"operation": "PATCH", "object": ` + pod + `}`,
+			wantError: true,
+		},
+		{
+			desc:      "No request",
+			wantError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			content := `{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview"}`
+			if tc.request != "" {
+				content = `{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview", "request": ` + tc.request + `}`
+			}
+			var u unstructured.Unstructured
+			if err := u.UnmarshalJSON([]byte(content)); err != nil {
+				t.Fatal(err)
+			}
+			if !IsAdmissionReview(&u) {
+				t.Fatalf("expected an AdmissionReview")
+			}
+
+			o, err := FromAdmissionReview(&u)
+			if tc.wantError {
+				if err == nil {
+					t.Errorf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if o.Operation() != tc.operation || o.Object.GetName() != "frontend-1" {
+				t.Errorf("unexpected object: %s %v", o.Operation(), o.Object)
+			}
+
+			input, err := ReviewInput(o, nil)
+			if err != nil {
+				t.Fatalf("review input: %s", err)
+			}
+
+			// The object of a DELETE request is its oldObject, as in the
+			// Gatekeeper webhook.
+			review := input["review"].(map[string]any)
+			for _, field := range []string{"object", "oldObject"} {
+				if protected, _, _ := unstructured.NestedString(review, field, "metadata", "labels", "protected"); protected != "true" {
+					t.Errorf("expected the pod in %s, got %v", field, review[field])
+				}
+			}
+			if username, _, _ := unstructured.NestedString(review, "userInfo", "username"); username != "alice" {
+				t.Errorf("expected the userInfo of the request, got %v", review["userInfo"])
+			}
+			if review["operation"] != string(tc.operation) || review["name"] != "frontend-1" || review["namespace"] != "prod-eu" {
+				t.Errorf("unexpected review: %v", review)
+			}
+		})
+	}
+}
+
+func TestHandlesOperation(t *testing.T) {
+	testCases := []struct {
+		operations []string
+		operation  admissionv1.Operation
+		want       bool
+	}{
+		{nil, admissionv1.Delete, true},
+		{[]string{"*"}, admissionv1.Connect, true},
+		{[]string{"CREATE", "UPDATE"}, admissionv1.Update, true},
+		{[]string{"DELETE"}, admissionv1.Create, false},
+	}
+
+	for _, tc := range testCases {
+		if got := HandlesOperation(tc.operations, tc.operation); got != tc.want {
+			t.Errorf("HandlesOperation(%v, %s) = %v, want %v", tc.operations, tc.operation, got, tc.want)
+		}
+	}
+}
+
+func TestInventory(t *testing.T) {
+	objects := []*unstructured.Unstructured{
+		newObject("v1", "Namespace", "", "prod-eu", nil),
+		newObject("networking.k8s.io/v1", "Ingress", "prod-eu", "frontend", nil),
+	}
+
+	inventory, err := Inventory(objects)
+	if err != nil {
+		t.Fatalf("inventory: %s", err)
+	}
+
+	if _, ok, _ := unstructured.NestedMap(inventory, "cluster", "v1", "Namespace", "prod-eu"); !ok {
+		t.Errorf("expected the Namespace at cluster/v1/Namespace/prod-eu, got %v", inventory)
+	}
+	if _, ok, _ := unstructured.NestedMap(inventory, "namespace", "prod-eu", "networking.k8s.io/v1", "Ingress", "frontend"); !ok {
+		t.Errorf("expected the Ingress at namespace/prod-eu/networking.k8s.io/v1/Ingress/frontend, got %v", inventory)
+	}
+
+	if _, err := Inventory([]*unstructured.Unstructured{newObject("", "Pod", "default", "nginx", nil)}); err == nil {
+		t.Errorf("expected error for a resource without a version, got none")
+	}
+}
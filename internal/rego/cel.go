@@ -0,0 +1,141 @@
+package rego
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/cel-go/cel"
+	"sigs.k8s.io/yaml"
+)
+
+// CELFileName is the name of the file next to the Rego of a policy with the
+// CEL validations of the policy.
+const CELFileName = "src.cel.yaml"
+
+// CELEngine is the name of the Gatekeeper engine that evaluates CEL.
+const CELEngine = "K8sNativeValidation"
+
+// CELSource is the source of the K8sNativeValidation engine of a
+// ConstraintTemplate, as read from the CEL file of a policy.
+type CELSource struct {
+	Validations     []CELValidation     `json:"validations"`
+	Variables       []CELVariable       `json:"variables,omitempty"`
+	MatchConditions []CELMatchCondition `json:"matchConditions,omitempty"`
+	FailurePolicy   string              `json:"failurePolicy,omitempty"`
+}
+
+// CELValidation is an expression that must evaluate to true for a resource
+// to be allowed.
+type CELValidation struct {
+	Expression        string `json:"expression"`
+	Message           string `json:"message,omitempty"`
+	MessageExpression string `json:"messageExpression,omitempty"`
+}
+
+// CELVariable is a named expression that validations can use as
+// variables.<name>.
+type CELVariable struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+}
+
+// CELMatchCondition is an expression that must evaluate to true for the
+// validations to apply to a resource.
+type CELMatchCondition struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+}
+
+// readCEL reads the CEL file in the directory of a policy, and returns nil
+// when there is none.
+func readCEL(directory string) (*CELSource, error) {
+	content, err := os.ReadFile(filepath.Join(directory, CELFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", CELFileName, err)
+	}
+
+	var source CELSource
+	if err := yaml.UnmarshalStrict(content, &source); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %w", CELFileName, err)
+	}
+	if err := source.validate(); err != nil {
+		return nil, err
+	}
+
+	return &source, nil
+}
+
+// validate checks that the CEL source is complete, and that its expressions
+// can be parsed. The expressions are not type checked, since their variables
+// are only known to Gatekeeper.
+func (s CELSource) validate() error {
+	if len(s.Validations) == 0 {
+		return fmt.Errorf("no validations")
+	}
+
+	switch s.FailurePolicy {
+	case "", "Fail", "Ignore":
+	default:
+		return fmt.Errorf("invalid failurePolicy %q, must be one of Fail or Ignore", s.FailurePolicy)
+	}
+
+	env, err := cel.NewEnv()
+	if err != nil {
+		return fmt.Errorf("new CEL environment: %w", err)
+	}
+	parse := func(kind string, expression string) error {
+		if expression == "" {
+			return fmt.Errorf("%s has no expression", kind)
+		}
+		if _, issues := env.Parse(expression); issues.Err() != nil {
+			return fmt.Errorf("parse %s: %w", kind, issues.Err())
+		}
+		return nil
+	}
+
+	for i, v := range s.Validations {
+		if err := parse(fmt.Sprintf("validations[%d]", i), v.Expression); err != nil {
+			return err
+		}
+		if v.MessageExpression != "" {
+			if err := parse(fmt.Sprintf("validations[%d].messageExpression", i), v.MessageExpression); err != nil {
+				return err
+			}
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, v := range s.Variables {
+		if v.Name == "" {
+			return fmt.Errorf("variable has no name")
+		}
+		if names[v.Name] {
+			return fmt.Errorf("duplicate variable %q", v.Name)
+		}
+		names[v.Name] = true
+		if err := parse(fmt.Sprintf("variable %q", v.Name), v.Expression); err != nil {
+			return err
+		}
+	}
+
+	names = make(map[string]bool)
+	for _, c := range s.MatchConditions {
+		if c.Name == "" {
+			return fmt.Errorf("match condition has no name")
+		}
+		if names[c.Name] {
+			return fmt.Errorf("duplicate match condition %q", c.Name)
+		}
+		names[c.Name] = true
+		if err := parse(fmt.Sprintf("match condition %q", c.Name), c.Expression); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
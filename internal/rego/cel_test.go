@@ -0,0 +1,95 @@
+package rego
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadCEL(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		content  string
+		expected string
+	}{
+		{
+			desc: "Valid",
+			content: `
+variables:
+- name: containers
+  expression: object.spec.containers
+matchConditions:
+- name: not-kube-system
+  expression: object.metadata.namespace != "kube-system"
+validations:
+- expression: "!variables.containers.exists(c, c.securityContext.privileged)"
+  messageExpression: "'privileged containers in ' + object.metadata.name"
+failurePolicy: Ignore`,
+		},
+		{
+			desc:     "Unknown field",
+			content:  `validation: [{expression: "true"}]`,
+			expected: `unknown field "validation"`,
+		},
+		{
+			desc:     "No validations",
+			content:  `variables: [{name: foo, expression: "true"}]`,
+			expected: "no validations",
+		},
+		{
+			desc:     "Unparsable expression",
+			content:  `validations: [{expression: "object.spec +"}]`,
+			expected: "parse validations[0]",
+		},
+		{
+			desc:     "Unparsable message expression",
+			content:  `validations: [{expression: "true", messageExpression: "'foo' +"}]`,
+			expected: "parse validations[0].messageExpression",
+		},
+		{
+			desc: "Duplicate variable",
+			content: `
+variables:
+- {name: foo, expression: "true"}
+- {name: foo, expression: "false"}
+validations: [{expression: "variables.foo"}]`,
+			expected: `duplicate variable "foo"`,
+		},
+		{
+			desc:     "Invalid failure policy",
+			content:  `{validations: [{expression: "true"}], failurePolicy: Deny}`,
+			expected: `invalid failurePolicy "Deny"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, CELFileName), []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			source, err := readCEL(dir)
+			if tc.expected == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if source == nil || len(source.Validations) != 1 {
+					t.Errorf("expected one validation, got %+v", source)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+
+	t.Run("No file", func(t *testing.T) {
+		source, err := readCEL(t.TempDir())
+		if err != nil || source != nil {
+			t.Errorf("expected no source and no error, got %+v and %v", source, err)
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package rego
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// SyncData is a set of equivalent resources that Gatekeeper must replicate
+// into data.inventory for a policy, in the format of the
+// metadata.gatekeeper.sh/requires-sync-data annotation. Replicating any
+// combination of the groups, versions and kinds satisfies the requirement.
+type SyncData struct {
+	Groups   []string `json:"groups"`
+	Versions []string `json:"versions"`
+	Kinds    []string `json:"kinds"`
+}
+
+// GVK is a group, version and kind of a resource.
+type GVK struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+// GVKs returns every combination of the groups, versions and kinds.
+func (s SyncData) GVKs() []GVK {
+	var gvks []GVK
+	for _, group := range s.Groups {
+		for _, version := range s.Versions {
+			for _, kind := range s.Kinds {
+				gvks = append(gvks, GVK{Group: group, Version: version, Kind: kind})
+			}
+		}
+	}
+
+	return gvks
+}
+
+// inventoryUsage describes which resources the rules of a policy read from
+// data.inventory.
+type inventoryUsage struct {
+	// gvks are the resources that are read, by group, version and kind.
+	gvks map[GVK]bool
+
+	// dynamic is the location of the first read of data.inventory whose
+	// group, version or kind cannot be determined, such as
+	// data.inventory.cluster[gv][kind], or nil.
+	dynamic *ast.Location
+}
+
+// requirements returns a requirement for each resource that is read, sorted
+// by group, version and kind.
+func (u inventoryUsage) requirements() [][]SyncData {
+	gvks := make([]GVK, 0, len(u.gvks))
+	for gvk := range u.gvks {
+		gvks = append(gvks, gvk)
+	}
+	sort.Slice(gvks, func(i, j int) bool {
+		if gvks[i].Group != gvks[j].Group {
+			return gvks[i].Group < gvks[j].Group
+		}
+		if gvks[i].Version != gvks[j].Version {
+			return gvks[i].Version < gvks[j].Version
+		}
+		return gvks[i].Kind < gvks[j].Kind
+	})
+
+	var requirements [][]SyncData
+	for _, gvk := range gvks {
+		requirements = append(requirements, []SyncData{{
+			Groups:   []string{gvk.Group},
+			Versions: []string{gvk.Version},
+			Kinds:    []string{gvk.Kind},
+		}})
+	}
+
+	return requirements
+}
+
+// getInventoryUsage returns the resources read from data.inventory by the
+// compiled rules, and by every rule that they depend on.
+func getInventoryUsage(graph *ast.Graph, rules []*ast.Rule) inventoryUsage {
+	usage := inventoryUsage{gvks: make(map[GVK]bool)}
+
+	seen := make(map[*ast.Rule]bool)
+	queue := append([]*ast.Rule{}, rules...)
+	for len(queue) > 0 {
+		rule := queue[0]
+		queue = queue[1:]
+		if seen[rule] {
+			continue
+		}
+		seen[rule] = true
+
+		ast.WalkRefs(rule, func(ref ast.Ref) bool {
+			usage.addRef(ref)
+			return false
+		})
+
+		for dependency := range graph.Dependencies(rule) {
+			if r, ok := dependency.(*ast.Rule); ok {
+				queue = append(queue, r)
+			}
+		}
+	}
+
+	return usage
+}
+
+// addRef adds the resource that the reference reads from data.inventory,
+// which has the layout cluster[<groupVersion>][<kind>] for cluster scoped
+// resources, and namespace[<namespace>][<groupVersion>][<kind>] for namespaced
+// resources.
+func (u *inventoryUsage) addRef(ref ast.Ref) {
+	if len(ref) < 2 || !ref[0].Equal(ast.DefaultRootDocument) || !ref[1].Equal(ast.StringTerm("inventory")) {
+		return
+	}
+
+	var gvIndex int
+	switch {
+	case len(ref) > 2 && ref[2].Equal(ast.StringTerm("cluster")):
+		gvIndex = 3
+	case len(ref) > 2 && ref[2].Equal(ast.StringTerm("namespace")):
+		gvIndex = 4
+	default:
+		u.addDynamic(ref[0].Location)
+		return
+	}
+
+	if len(ref) <= gvIndex+1 {
+		u.addDynamic(ref[0].Location)
+		return
+	}
+
+	groupVersion, ok := ref[gvIndex].Value.(ast.String)
+	if !ok {
+		u.addDynamic(ref[0].Location)
+		return
+	}
+	kind, ok := ref[gvIndex+1].Value.(ast.String)
+	if !ok {
+		u.addDynamic(ref[0].Location)
+		return
+	}
+
+	group, version := "", string(groupVersion)
+	if i := strings.LastIndex(version, "/"); i >= 0 {
+		group, version = version[:i], version[i+1:]
+	}
+	u.gvks[GVK{Group: group, Version: version, Kind: string(kind)}] = true
+}
+
+func (u *inventoryUsage) addDynamic(location *ast.Location) {
+	if u.dynamic == nil {
+		u.dynamic = location
+	}
+}
@@ -0,0 +1,230 @@
+package rego
+
+import (
+	"sort"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/loader"
+)
+
+// parameterUsage describes how the rules of a policy read its parameters.
+type parameterUsage struct {
+	// locations are the locations of the first read of each parameter.
+	locations map[string]*ast.Location
+
+	// dynamic is the location of the first read of input.parameters whose
+	// parameter cannot be determined, such as input.parameters[name], or nil.
+	dynamic *ast.Location
+}
+
+// names returns the names of the parameters that are read, sorted by name.
+func (u parameterUsage) names() []string {
+	names := make([]string, 0, len(u.locations))
+	for name := range u.locations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (u *parameterUsage) add(name string, location *ast.Location) {
+	if _, ok := u.locations[name]; !ok {
+		u.locations[name] = location
+	}
+}
+
+func (u *parameterUsage) addDynamic(location *ast.Location) {
+	if u.dynamic == nil {
+		u.dynamic = location
+	}
+}
+
+// getParameterUsage returns the parameters read by the compiled rules, and by
+// every rule that they depend on, such as the helpers of imported libraries.
+func getParameterUsage(graph *ast.Graph, rules []*ast.Rule) parameterUsage {
+	usage := parameterUsage{locations: make(map[string]*ast.Location)}
+
+	seen := make(map[*ast.Rule]bool)
+	queue := append([]*ast.Rule{}, rules...)
+	for len(queue) > 0 {
+		rule := queue[0]
+		queue = queue[1:]
+		if seen[rule] {
+			continue
+		}
+		seen[rule] = true
+
+		usage.addRule(rule)
+
+		for dependency := range graph.Dependencies(rule) {
+			if r, ok := dependency.(*ast.Rule); ok {
+				queue = append(queue, r)
+			}
+		}
+	}
+
+	return usage
+}
+
+// addRule adds the parameters read by the rule. Variables that are assigned
+// input or input.parameters are followed, so that p := input.parameters
+// followed by p.labels is a read of the labels parameter.
+func (u *parameterUsage) addRule(rule *ast.Rule) {
+	aliases := make(map[ast.Var][]string)
+	for changed := true; changed; {
+		changed = false
+		ast.WalkExprs(rule, func(expr *ast.Expr) bool {
+			if v, path, ok := getAlias(expr, aliases); ok && aliases[v] == nil {
+				aliases[v] = path
+				changed = true
+			}
+			return false
+		})
+	}
+
+	// The input.parameters term of object.get(input.parameters, "name", ...)
+	// is a read of a single parameter, rather than a dynamic read.
+	handled := make(map[*ast.Term]bool)
+	addCall := func(operator ast.Ref, args []*ast.Term) {
+		if !operator.Equal(ast.ObjectGet.Ref()) || len(args) < 2 {
+			return
+		}
+		path, complete := resolveRef(args[0], aliases)
+		name, ok := args[1].Value.(ast.String)
+		if complete && ok && isParametersPath(path) && len(path) == 2 {
+			u.add(string(name), args[0].Location)
+			handled[args[0]] = true
+		}
+	}
+
+	ast.NewGenericVisitor(func(x any) bool {
+		switch x := x.(type) {
+		case *ast.Expr:
+			if _, _, ok := getAlias(x, aliases); ok {
+				return true
+			}
+			if x.IsCall() {
+				addCall(x.Operator(), x.Operands())
+			}
+
+		case *ast.Term:
+			if call, ok := x.Value.(ast.Call); ok && len(call) > 0 {
+				if operator, ok := call[0].Value.(ast.Ref); ok {
+					addCall(operator, call[1:])
+				}
+			}
+
+			if handled[x] {
+				return true
+			}
+			path, _ := resolveRef(x, aliases)
+			if !isParametersPath(path) {
+				return false
+			}
+			if len(path) > 2 {
+				u.add(path[2], x.Location)
+			} else {
+				u.addDynamic(x.Location)
+			}
+
+			// The parts of the reference are not reads on their own.
+			return true
+		}
+
+		return false
+	}).Walk(rule)
+}
+
+// getAlias returns the variable of an expression such as p = input.parameters,
+// and the path that it refers to.
+func getAlias(expr *ast.Expr, aliases map[ast.Var][]string) (ast.Var, []string, bool) {
+	if !expr.IsEquality() && !expr.IsAssignment() {
+		return "", nil, false
+	}
+
+	operands := expr.Operands()
+	for i, operand := range operands {
+		v, ok := operand.Value.(ast.Var)
+		if !ok {
+			continue
+		}
+
+		path, complete := resolveRef(operands[1-i], aliases)
+		if complete && (len(path) == 1 || (len(path) == 2 && isParametersPath(path))) {
+			return v, path, true
+		}
+	}
+
+	return "", nil, false
+}
+
+// resolveRef returns the path of the input document that the term refers to,
+// up to the first part that is not a string. The path is complete when all
+// parts of the reference are strings.
+func resolveRef(term *ast.Term, aliases map[ast.Var][]string) ([]string, bool) {
+	var ref ast.Ref
+	switch v := term.Value.(type) {
+	case ast.Ref:
+		ref = v
+	case ast.Var:
+		ref = ast.Ref{term}
+	default:
+		return nil, false
+	}
+
+	head, ok := ref[0].Value.(ast.Var)
+	if !ok {
+		return nil, false
+	}
+
+	var path []string
+	switch {
+	case head.Equal(ast.InputRootDocument.Value):
+		path = []string{"input"}
+	case aliases[head] != nil:
+		path = append(path, aliases[head]...)
+	default:
+		return nil, false
+	}
+
+	for _, part := range ref[1:] {
+		s, ok := part.Value.(ast.String)
+		if !ok {
+			return path, false
+		}
+		path = append(path, string(s))
+	}
+
+	return path, true
+}
+
+func isParametersPath(path []string) bool {
+	return len(path) >= 2 && path[0] == "input" && path[1] == "parameters"
+}
+
+// getParameterDeclarations returns the location of the METADATA block that
+// declares each parameter in its custom.parameters annotation. OPA only keeps
+// the location of the block, which points at the file that declares the
+// parameters when the policy is split across multiple files.
+func getParameterDeclarations(files []*loader.RegoFile) map[string]*ast.Location {
+	declarations := make(map[string]*ast.Location)
+	for _, file := range files {
+		annotations := getPackageAnnotations(file.Parsed)
+		if annotations == nil {
+			continue
+		}
+
+		parameters, ok := annotations.Custom[annoParameters].(map[string]any)
+		if !ok {
+			continue
+		}
+		for name := range parameters {
+			if _, ok := declarations[name]; !ok {
+				declarations[name] = annotations.Location
+			}
+		}
+	}
+
+	return declarations
+}
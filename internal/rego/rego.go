@@ -3,19 +3,29 @@ package rego
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"slices"
 	"sort"
 	"strings"
 
+	"github.com/plexsystems/konstraint/internal/diagnostic"
+
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/loader"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiservervalidation "k8s.io/apiextensions-apiserver/pkg/apiserver/validation"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
 // Severity describes the severity level of the rego file.
@@ -40,39 +50,205 @@ const (
 	annoSkipConstraint = "skipConstraint"
 	annoAnnotations    = "annotations"
 	annoLabels         = "labels"
+	annoConstraints    = "constraints"
+	annoKind           = "kind"
+	annoName           = "name"
+	annoTests          = "tests"
+	annoVersion        = "version"
+
+	annoScopedEnforcementActions = "scopedEnforcementActions"
+	annoRequiresSyncData         = "requiresSyncData"
+	annoTemplateAnnotations      = "templateAnnotations"
+	annoTemplateLabels           = "templateLabels"
+	annoGenerateVAP              = "generateVAP"
+	annoOperations               = "operations"
+)
+
+// knownAnnotationKeys are all keys of the custom section of the metadata
+// annotations that Konstraint uses.
+var knownAnnotationKeys = []string{
+	annoEnforcement,
+	annoMatchers,
+	annoParameters,
+	annoSkipTemplate,
+	annoSkipConstraint,
+	annoAnnotations,
+	annoLabels,
+	annoConstraints,
+	annoKind,
+	annoName,
+	annoTests,
+	annoScopedEnforcementActions,
+	annoRequiresSyncData,
+	annoTemplateAnnotations,
+	annoTemplateLabels,
+	annoVersion,
+	annoGenerateVAP,
+	annoOperations,
+}
+
+// Enforcement points of Gatekeeper that scoped enforcement actions can apply to.
+const (
+	EnforcementPointValidation = "validation.gatekeeper.sh"
+	EnforcementPointAudit      = "audit.gatekeeper.sh"
+	EnforcementPointGator      = "gator.gatekeeper.sh"
+	EnforcementPointVAP        = "vap.k8s.io"
+
+	// EnforcementPointAll applies the enforcement action to all enforcement points.
+	EnforcementPointAll = "*"
 )
 
+// EnforcementPoints are all enforcement points of Gatekeeper.
+var EnforcementPoints = []string{
+	EnforcementPointValidation,
+	EnforcementPointAudit,
+	EnforcementPointGator,
+	EnforcementPointVAP,
+}
+
+// Operations are the admission operations that a ConstraintTemplate can be
+// evaluated for. They are the operations of an admission request, so that
+// every operation can be reviewed.
+var Operations = []string{
+	string(admissionv1.Create),
+	string(admissionv1.Update),
+	string(admissionv1.Delete),
+	string(admissionv1.Connect),
+}
+
+// OperationAll evaluates a ConstraintTemplate for all admission operations.
+const OperationAll = "*"
+
 const (
 	coreAPIGroup     = "core"
 	coreAPIShorthand = ""
 )
 
+// kindRE matches a valid CamelCase Kubernetes kind.
+var kindRE = regexp.MustCompile(`^[A-Z][a-zA-Z0-9]*$`)
+
 type MetaData struct {
-	Annotations map[string]string
-	Labels      map[string]string
+	Annotations         map[string]string
+	Labels              map[string]string
+	TemplateAnnotations map[string]string
+	TemplateLabels      map[string]string
 }
 
 // Rego represents a parsed rego file.
 type Rego struct {
 	id             string
 	path           string
+	packagePath    string
 	raw            string
 	sanitizedRaw   string
 	rules          []string
 	dependencies   []string
 	enforcement    string
+	scopedActions  []ScopedEnforcementAction
 	skipTemplate   bool
 	skipConstraint bool
 	metaData       *MetaData
+	location       *ast.Location
+	inputParams    []string
+	dynamicParams  bool
+	paramDecls     map[string]*ast.Location
+	syncData       [][]SyncData
+	dynamicSync    *ast.Location
+	cel            *CELSource
 	// Duplicate data from OPA Metadata annotations.
-	annotations                   *ast.Annotations
-	annoTitle                     string
-	annoDescription               string
-	annoParameters                map[string]apiextensionsv1.JSONSchemaProps
-	annoKindMatchers              []AnnoKindMatcher
-	annoNamespaceMatchers         []string
-	annoExcludedNamespaceMatchers []string
-	annoLabelSelector             *metav1.LabelSelector
+	annotations     *ast.Annotations
+	annoTitle       string
+	annoKind        string
+	annoName        string
+	annoDescription string
+	annoParameters  map[string]apiextensionsv1.JSONSchemaProps
+	annoMatch       AnnoMatch
+	annoConstraints []AnnoConstraint
+	annoTests       AnnoTests
+	annoSyncData    [][]SyncData
+	annoVersion     string
+	generateVAP     *bool
+	annoOperations  []string
+	// The Constraint instance this Rego describes, set by ForConstraint.
+	constraint *AnnoConstraint
+}
+
+// AnnoMatch is the match field of a Gatekeeper Constraint, as set in the
+// custom.matchers annotation.
+type AnnoMatch struct {
+	Kinds              []AnnoKindMatcher     `json:"kinds,omitempty"`
+	Scope              string                `json:"scope,omitempty"`
+	Namespaces         []string              `json:"namespaces,omitempty"`
+	ExcludedNamespaces []string              `json:"excludedNamespaces,omitempty"`
+	LabelSelector      *metav1.LabelSelector `json:"labelSelector,omitempty"`
+	NamespaceSelector  *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	Name               string                `json:"name,omitempty"`
+	Source             string                `json:"source,omitempty"`
+}
+
+// wildcardRE matches the names that Gatekeeper accepts in the namespaces,
+// excludedNamespaces and name matchers, which may have a prefix or suffix
+// wildcard.
+var wildcardRE = regexp.MustCompile(`^(\*|\*-)?[a-z0-9]([-:a-z0-9]*[a-z0-9])?(\*|-\*)?$`)
+
+func (m AnnoMatch) validate() error {
+	switch m.Scope {
+	case "", "*", "Cluster", "Namespaced":
+	default:
+		return fmt.Errorf("invalid scope %q, must be one of *, Cluster or Namespaced", m.Scope)
+	}
+
+	switch m.Source {
+	case "", "All", "Generated", "Original":
+	default:
+		return fmt.Errorf("invalid source %q, must be one of All, Generated or Original", m.Source)
+	}
+
+	for _, ns := range m.Namespaces {
+		if !wildcardRE.MatchString(ns) {
+			return fmt.Errorf("invalid namespaces pattern %q", ns)
+		}
+	}
+	for _, ns := range m.ExcludedNamespaces {
+		if !wildcardRE.MatchString(ns) {
+			return fmt.Errorf("invalid excludedNamespaces pattern %q", ns)
+		}
+	}
+	if m.Name != "" && !wildcardRE.MatchString(m.Name) {
+		return fmt.Errorf("invalid name pattern %q", m.Name)
+	}
+
+	if err := validateLabelSelector(m.LabelSelector); err != nil {
+		return fmt.Errorf("invalid labelSelector: %w", err)
+	}
+	if err := validateLabelSelector(m.NamespaceSelector); err != nil {
+		return fmt.Errorf("invalid namespaceSelector: %w", err)
+	}
+
+	return nil
+}
+
+func validateLabelSelector(selector *metav1.LabelSelector) error {
+	if selector == nil {
+		return nil
+	}
+
+	for _, expr := range selector.MatchExpressions {
+		switch expr.Operator {
+		case metav1.LabelSelectorOpIn, metav1.LabelSelectorOpNotIn:
+			if len(expr.Values) == 0 {
+				return fmt.Errorf("operator %s of key %s requires values", expr.Operator, expr.Key)
+			}
+		case metav1.LabelSelectorOpExists, metav1.LabelSelectorOpDoesNotExist:
+			if len(expr.Values) > 0 {
+				return fmt.Errorf("operator %s of key %s does not allow values", expr.Operator, expr.Key)
+			}
+		default:
+			return fmt.Errorf("invalid operator %q of key %s, must be one of In, NotIn, Exists or DoesNotExist", expr.Operator, expr.Key)
+		}
+	}
+
+	return nil
 }
 
 type AnnoKindMatcher struct {
@@ -93,6 +269,38 @@ func (akm AnnoKindMatcher) String() string {
 	return strings.TrimSpace(result)
 }
 
+// AnnoConstraint is a single Constraint instance declared in the
+// custom.constraints annotation of a policy.
+type AnnoConstraint struct {
+	Name                     string                    `json:"name"`
+	Enforcement              string                    `json:"enforcement,omitempty"`
+	ScopedEnforcementActions []ScopedEnforcementAction `json:"scopedEnforcementActions,omitempty"`
+	Matchers                 map[string]any            `json:"matchers,omitempty"`
+	Parameters               map[string]any            `json:"parameters,omitempty"`
+	GenerateVAP              *bool                     `json:"generateVAP,omitempty"`
+}
+
+// ScopedEnforcementAction is an enforcement action that only applies to the
+// given enforcement points, used with the scoped enforcement action.
+type ScopedEnforcementAction struct {
+	Action            string             `json:"action"`
+	EnforcementPoints []EnforcementPoint `json:"enforcementPoints"`
+}
+
+// EnforcementPoint is a component of Gatekeeper that enforces constraints.
+type EnforcementPoint struct {
+	Name string `json:"name"`
+}
+
+// AnnoTests are the fixtures of a policy declared in the custom.tests
+// annotation. The paths are relative to the directory of the policy.
+type AnnoTests struct {
+	// Allowed are files with resources that must not cause a violation.
+	Allowed []string `json:"allowed,omitempty"`
+	// Disallowed are files with resources that must cause a violation.
+	Disallowed []string `json:"disallowed,omitempty"`
+}
+
 // Parameter represents a parameter that the policy uses
 type Parameter struct {
 	Name        string
@@ -103,7 +311,8 @@ type Parameter struct {
 
 // GetAllSeverities gets all of the rego files found in the given directory as
 // well as any subdirectories. Only rego files that contain a valid severity
-// will be returned.
+// will be returned. Like GetViolations, the valid policies are returned along
+// with a diagnostic.List error for the invalid ones.
 func GetAllSeverities(directory string) ([]Rego, error) {
 	return getAllSeverities(directory, true)
 }
@@ -118,7 +327,7 @@ func GetAllSeveritiesWithoutImports(directory string) ([]Rego, error) {
 func getAllSeverities(directory string, parseImports bool) ([]Rego, error) {
 	regos, err := parseDirectory(directory, parseImports)
 	if err != nil {
-		return nil, fmt.Errorf("parse directory: %w", err)
+		err = fmt.Errorf("parse directory: %w", err)
 	}
 
 	var allSeverities []Rego
@@ -130,16 +339,17 @@ func getAllSeverities(directory string, parseImports bool) ([]Rego, error) {
 		allSeverities = append(allSeverities, rego)
 	}
 
-	return allSeverities, nil
+	return allSeverities, err
 }
 
 // GetViolations gets all of the files found in the given directory as well as
 // any subdirectories. Only rego files that have a severity of violation will
-// be returned.
+// be returned. Problems with individual policies are returned as a
+// diagnostic.List error, along with the policies that are valid.
 func GetViolations(directory string) ([]Rego, error) {
 	regos, err := parseDirectory(directory, true)
 	if err != nil {
-		return nil, fmt.Errorf("parse directory: %w", err)
+		err = fmt.Errorf("parse directory: %w", err)
 	}
 
 	var violations []Rego
@@ -151,7 +361,7 @@ func GetViolations(directory string) ([]Rego, error) {
 		violations = append(violations, rego)
 	}
 
-	return violations, nil
+	return violations, err
 }
 
 // Path returns the original path of the rego file.
@@ -159,26 +369,193 @@ func (r Rego) Path() string {
 	return r.path
 }
 
+// PackagePath returns the path of the package of the policy, such as
+// data.pod_deny_host_network.
+func (r Rego) PackagePath() string {
+	return r.packagePath
+}
+
+// AnnotationMatchers returns all matchers set in the matchers annotation.
+func (r Rego) AnnotationMatchers() AnnoMatch {
+	return r.annoMatch
+}
+
 func (r Rego) AnnotationKindMatchers() []AnnoKindMatcher {
-	return r.annoKindMatchers
+	return r.annoMatch.Kinds
+}
+
+func (r Rego) AnnotationScopeMatcher() string {
+	return r.annoMatch.Scope
 }
 
 func (r Rego) AnnotationNamespaceMatchers() []string {
-	return r.annoNamespaceMatchers
+	return r.annoMatch.Namespaces
 }
 
 func (r Rego) AnnotationExcludedNamespaceMatchers() []string {
-	return r.annoExcludedNamespaceMatchers
+	return r.annoMatch.ExcludedNamespaces
 }
 
 func (r Rego) AnnotationLabelSelectorMatcher() *metav1.LabelSelector {
-	return r.annoLabelSelector
+	return r.annoMatch.LabelSelector
+}
+
+func (r Rego) AnnotationNamespaceSelectorMatcher() *metav1.LabelSelector {
+	return r.annoMatch.NamespaceSelector
+}
+
+func (r Rego) AnnotationNameMatcher() string {
+	return r.annoMatch.Name
+}
+
+func (r Rego) AnnotationSourceMatcher() string {
+	return r.annoMatch.Source
 }
 
 func (r Rego) AnnotationParameters() map[string]apiextensionsv1.JSONSchemaProps {
 	return r.annoParameters
 }
 
+// AnnotationConstraints returns the Constraint instances declared in the
+// custom.constraints annotation.
+func (r Rego) AnnotationConstraints() []AnnoConstraint {
+	return r.annoConstraints
+}
+
+// ConstraintParameters returns the parameter values of the Constraint instance
+// the Rego describes. It is only set on copies returned by ForConstraint.
+func (r Rego) ConstraintParameters() map[string]any {
+	if r.constraint == nil {
+		return nil
+	}
+	return r.constraint.Parameters
+}
+
+// Location returns the location of the metadata annotations of the policy,
+// or the location of its package when it has no annotations.
+func (r Rego) Location() *ast.Location {
+	return r.location
+}
+
+// InputParameters returns the names of the parameters that the rules of the
+// policy, and the library rules they use, read from input.parameters, sorted
+// by name.
+func (r Rego) InputParameters() []string {
+	return r.inputParams
+}
+
+// ParameterLocation returns the location of the METADATA block that declares
+// the parameter in the custom.parameters annotation, or the location of the
+// policy when it is not declared.
+func (r Rego) ParameterLocation(name string) *ast.Location {
+	if location, ok := r.paramDecls[name]; ok {
+		return location
+	}
+
+	return r.location
+}
+
+// UnusedParameters returns the names of the declared parameters that the
+// policy never reads, sorted by name. When the policy reads a parameter whose
+// name cannot be determined, such as input.parameters[name], all parameters
+// are considered to be used.
+func (r Rego) UnusedParameters() []string {
+	if r.dynamicParams {
+		return nil
+	}
+
+	var unused []string
+	for name := range r.annoParameters {
+		if !slices.Contains(r.inputParams, name) {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+
+	return unused
+}
+
+// RequiresSyncData returns the resources that Gatekeeper must replicate into
+// data.inventory for the policy. They are set in the requiresSyncData
+// annotation, or else found in the reads of data.inventory of the rules of the
+// policy and the library rules they use.
+func (r Rego) RequiresSyncData() [][]SyncData {
+	if r.annoSyncData != nil {
+		return r.annoSyncData
+	}
+
+	return r.syncData
+}
+
+// UndeterminedSyncData returns the location of the first read of
+// data.inventory whose resource cannot be determined, such as
+// data.inventory.cluster[gv][kind]. It is nil when there is none, or when the
+// resources are set in the requiresSyncData annotation.
+func (r Rego) UndeterminedSyncData() *ast.Location {
+	if r.annoSyncData != nil {
+		return nil
+	}
+
+	return r.dynamicSync
+}
+
+// UnknownAnnotationKeys returns the keys in the custom section of the
+// metadata annotations that Konstraint does not know, sorted by name.
+func (r Rego) UnknownAnnotationKeys() []string {
+	if r.annotations == nil {
+		return nil
+	}
+
+	var unknown []string
+	for key := range r.annotations.Custom {
+		if !slices.Contains(knownAnnotationKeys, key) {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+
+	return unknown
+}
+
+// AnnotationTests returns the fixtures set in the tests annotation.
+func (r Rego) AnnotationTests() AnnoTests {
+	return r.annoTests
+}
+
+// ForConstraint returns a copy of the Rego that describes the given Constraint
+// instance. The name, enforcement action and matchers of the copy reflect the
+// instance, falling back to the values of the policy when not set.
+func (r Rego) ForConstraint(c AnnoConstraint) (Rego, error) {
+	r.constraint = &c
+	if c.Enforcement != "" || c.ScopedEnforcementActions != nil {
+		r.enforcement = c.Enforcement
+		r.scopedActions = c.ScopedEnforcementActions
+		if err := validateEnforcement(r.enforcement, r.scopedActions); err != nil {
+			return Rego{}, fmt.Errorf("constraint %s: %w", c.Name, err)
+		}
+	}
+
+	if c.GenerateVAP != nil {
+		r.generateVAP = c.GenerateVAP
+	}
+
+	if c.Matchers != nil {
+		annotations := *r.annotations
+		annotations.Custom = make(map[string]any, len(r.annotations.Custom))
+		for k, v := range r.annotations.Custom {
+			annotations.Custom[k] = v
+		}
+		annotations.Custom[annoMatchers] = c.Matchers
+		r.annotations = &annotations
+
+		if err := r.parseAnnotationsMatchers(c.Matchers); err != nil {
+			return Rego{}, fmt.Errorf("parse matchers of constraint %s: %w", c.Name, err)
+		}
+	}
+
+	return r, nil
+}
+
 func (r Rego) GetAnnotation(name string) (any, error) {
 	if r.annotations == nil {
 		return nil, fmt.Errorf("no annotations set")
@@ -200,6 +577,13 @@ func (r *Rego) parseAnnotations(annotations *ast.Annotations) error {
 	if annotations == nil {
 		return nil
 	}
+
+	// The custom section is validated before it is converted, so that
+	// a value of the wrong type is reported by its key path.
+	if err := validateCustom(annotations.Custom); err != nil {
+		return err
+	}
+
 	if annotations.Title != "" {
 		r.annoTitle = annotations.Title
 	}
@@ -209,18 +593,67 @@ func (r *Rego) parseAnnotations(annotations *ast.Annotations) error {
 
 	matchers, ok := annotations.Custom[annoMatchers]
 	if ok {
-		if err := r.parseAnnotationsMatchers(matchers.(map[string]any)); err != nil {
+		if err := r.parseAnnotationsMatchers(matchers); err != nil {
 			return fmt.Errorf("parse matchers from OPA metadata: %w", err)
 		}
 	}
 
 	parameters, ok := annotations.Custom[annoParameters]
 	if ok {
-		if err := r.parseAnnotationsParameters(parameters.(map[string]any)); err != nil {
+		if err := r.parseAnnotationsParameters(parameters); err != nil {
 			return fmt.Errorf("parse parameters from OPA metadata: %w", err)
 		}
 	}
 
+	kind, ok := annotations.Custom[annoKind]
+	if ok {
+		k, ok := kind.(string)
+		if !ok {
+			return fmt.Errorf("supplied kind value is not a string: %T", kind)
+		}
+		if !kindRE.MatchString(k) {
+			return fmt.Errorf("supplied kind value is not a valid CamelCase kind: %s", k)
+		}
+		r.annoKind = k
+	}
+
+	name, ok := annotations.Custom[annoName]
+	if ok {
+		n, ok := name.(string)
+		if !ok {
+			return fmt.Errorf("supplied name value is not a string: %T", name)
+		}
+		if errs := validation.IsDNS1123Subdomain(n); len(errs) > 0 {
+			return fmt.Errorf("supplied name value is not a valid DNS-1123 name: %s", strings.Join(errs, ", "))
+		}
+		r.annoName = n
+	}
+
+	constraints, ok := annotations.Custom[annoConstraints]
+	if ok {
+		if err := r.parseAnnotationsConstraints(constraints); err != nil {
+			return fmt.Errorf("parse constraints from OPA metadata: %w", err)
+		}
+	}
+
+	tests, ok := annotations.Custom[annoTests]
+	if ok {
+		t, err := remarshalStrict[AnnoTests](tests)
+		if err != nil {
+			return fmt.Errorf("parse tests from OPA metadata: %w", err)
+		}
+		r.annoTests = t
+	}
+
+	syncData, ok := annotations.Custom[annoRequiresSyncData]
+	if ok {
+		sd, err := remarshalStrict[[][]SyncData](syncData)
+		if err != nil {
+			return fmt.Errorf("parse requiresSyncData from OPA metadata: %w", err)
+		}
+		r.annoSyncData = sd
+	}
+
 	skipTemplate, ok := annotations.Custom[annoSkipTemplate]
 	if ok {
 		st, ok := skipTemplate.(bool)
@@ -248,36 +681,128 @@ func (r *Rego) parseAnnotations(annotations *ast.Annotations) error {
 		r.enforcement = e
 	}
 
-	metaAnnotations, ok := annotations.Custom[annoAnnotations]
+	scopedActions, ok := annotations.Custom[annoScopedEnforcementActions]
 	if ok {
-		a, ok := metaAnnotations.(map[string]interface{})
+		sa, err := remarshal[[]ScopedEnforcementAction](scopedActions)
+		if err != nil {
+			return fmt.Errorf("unmarshal scopedEnforcementActions: %w", err)
+		}
+		r.scopedActions = sa
+	}
+
+	if err := validateEnforcement(r.enforcement, r.scopedActions); err != nil {
+		return err
+	}
+
+	var metaData MetaData
+	for _, m := range []struct {
+		key   string
+		field *map[string]string
+	}{
+		{annoAnnotations, &metaData.Annotations},
+		{annoLabels, &metaData.Labels},
+		{annoTemplateAnnotations, &metaData.TemplateAnnotations},
+		{annoTemplateLabels, &metaData.TemplateLabels},
+	} {
+		value, ok := annotations.Custom[m.key]
 		if !ok {
-			return fmt.Errorf("supplied annotations value is not a map[string]interface{}: %T", metaAnnotations)
+			continue
 		}
-		if r.metaData == nil {
-			r.metaData = &MetaData{}
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("supplied %s value is not a map[string]interface{}: %T", m.key, value)
 		}
-		ans, err := switchToMap(a)
+		stringValues, err := switchToMap(values)
 		if err != nil {
 			return err
 		}
-		r.metaData.Annotations = ans
+		*m.field = stringValues
+		r.metaData = &metaData
 	}
 
-	metaLabels, ok := annotations.Custom[annoLabels]
-	if ok {
-		l, ok := metaLabels.(map[string]interface{})
+	if generateVAP, ok := annotations.Custom[annoGenerateVAP]; ok {
+		v, ok := generateVAP.(bool)
 		if !ok {
-			return fmt.Errorf("supplied labels value is not a map[string]interface{}: %T", metaLabels)
+			return fmt.Errorf("supplied generateVAP value is not a boolean: %T", generateVAP)
 		}
-		if r.metaData == nil {
-			r.metaData = &MetaData{}
+		r.generateVAP = &v
+	}
+
+	if version, ok := annotations.Custom[annoVersion]; ok {
+		v, ok := version.(string)
+		if !ok {
+			return fmt.Errorf("supplied version value is not a string: %T", version)
 		}
-		labels, err := switchToMap(l)
+		r.annoVersion = v
+	}
+
+	if operations, ok := annotations.Custom[annoOperations]; ok {
+		o, err := remarshalStrict[[]string](operations)
 		if err != nil {
+			return fmt.Errorf("parse operations from OPA metadata: %w", err)
+		}
+		if err := validateOperations(o); err != nil {
 			return err
 		}
-		r.metaData.Labels = labels
+		r.annoOperations = o
+	}
+
+	return nil
+}
+
+// validateOperations checks that the admission operations are known, and
+// that the wildcard is not combined with other operations.
+func validateOperations(operations []string) error {
+	if len(operations) == 0 {
+		return fmt.Errorf("operations is empty")
+	}
+
+	seen := make(map[string]bool)
+	for _, o := range operations {
+		if o != OperationAll && !slices.Contains(Operations, o) {
+			return fmt.Errorf("invalid operation %q, must be one of %s or %s", o, strings.Join(Operations, ", "), OperationAll)
+		}
+		if seen[o] {
+			return fmt.Errorf("duplicate operation %q", o)
+		}
+		seen[o] = true
+	}
+	if seen[OperationAll] && len(operations) > 1 {
+		return fmt.Errorf("operation %s cannot be combined with other operations", OperationAll)
+	}
+
+	return nil
+}
+
+// validateEnforcement checks that scoped enforcement actions are only used
+// together with the scoped enforcement action, and only reference valid
+// actions and enforcement points.
+func validateEnforcement(enforcement string, scopedActions []ScopedEnforcementAction) error {
+	if enforcement == "scoped" && len(scopedActions) == 0 {
+		return fmt.Errorf("enforcement is scoped, but no scopedEnforcementActions are set")
+	}
+	if scopedActions == nil {
+		return nil
+	}
+	if enforcement != "" && enforcement != "scoped" {
+		return fmt.Errorf("scopedEnforcementActions are set, but enforcement is %s instead of scoped", enforcement)
+	}
+
+	for i, sa := range scopedActions {
+		switch sa.Action {
+		case "deny", "warn", "dryrun":
+		default:
+			return fmt.Errorf("invalid action %q in scopedEnforcementActions at index %d", sa.Action, i)
+		}
+
+		if len(sa.EnforcementPoints) == 0 {
+			return fmt.Errorf("no enforcementPoints set in scopedEnforcementActions at index %d", i)
+		}
+		for _, ep := range sa.EnforcementPoints {
+			if ep.Name != EnforcementPointAll && !contains(EnforcementPoints, ep.Name) {
+				return fmt.Errorf("invalid enforcement point %q in scopedEnforcementActions at index %d", ep.Name, i)
+			}
+		}
 	}
 
 	return nil
@@ -296,52 +821,89 @@ func switchToMap(in map[string]interface{}) (map[string]string, error) {
 	return out, nil
 }
 
-func (r *Rego) parseAnnotationsMatchers(matchers map[string]any) error {
-	kindMatchers, ok := matchers["kinds"]
-	if ok {
-		km, err := remarshal[[]AnnoKindMatcher](kindMatchers)
-		if err != nil {
-			return fmt.Errorf("unmarshal kind matchers: %w", err)
-		}
-		r.annoKindMatchers = km
+func (r *Rego) parseAnnotationsMatchers(matchers any) error {
+	match, err := remarshalStrict[AnnoMatch](matchers)
+	if err != nil {
+		return fmt.Errorf("unmarshal matchers: %w", err)
+	}
+	if err := match.validate(); err != nil {
+		return err
 	}
 
-	namespaceMatchers, ok := matchers["namespaces"]
-	if ok {
-		ns, err := remarshal[[]string](namespaceMatchers)
-		if err != nil {
-			return fmt.Errorf("unmarshal namespaces matcher: %w", err)
-		}
-		r.annoNamespaceMatchers = ns
+	r.annoMatch = match
+	return nil
+}
+
+func (r *Rego) parseAnnotationsParameters(parameters any) error {
+	params, err := remarshal[map[string]apiextensionsv1.JSONSchemaProps](parameters)
+	if err != nil {
+		return fmt.Errorf("unmarshal parameters: %w", err)
 	}
+	r.annoParameters = params
+	return nil
+}
 
-	excludedNamespaceMatchers, ok := matchers["excludedNamespaces"]
-	if ok {
-		ens, err := remarshal[[]string](excludedNamespaceMatchers)
-		if err != nil {
-			return fmt.Errorf("unmarshal excludedNamespaces matcher: %w", err)
-		}
-		r.annoExcludedNamespaceMatchers = ens
+func (r *Rego) parseAnnotationsConstraints(constraints any) error {
+	cs, err := remarshal[[]AnnoConstraint](constraints)
+	if err != nil {
+		return fmt.Errorf("unmarshal constraints: %w", err)
 	}
 
-	labelSelector, ok := matchers["labelSelector"]
-	if ok {
-		ls, err := remarshal[metav1.LabelSelector](labelSelector)
-		if err != nil {
-			return fmt.Errorf("unmarshal labelSelector matcher: %w", err)
+	names := make(map[string]bool)
+	for i, c := range cs {
+		if errs := validation.IsDNS1123Label(c.Name); len(errs) > 0 {
+			return fmt.Errorf("invalid name %q for constraint at index %d: %s", c.Name, i, strings.Join(errs, ", "))
+		}
+		if names[c.Name] {
+			return fmt.Errorf("duplicate constraint name: %s", c.Name)
+		}
+		names[c.Name] = true
+
+		if c.Matchers != nil {
+			var matchers Rego
+			if err := matchers.parseAnnotationsMatchers(c.Matchers); err != nil {
+				return fmt.Errorf("constraint %s: %w", c.Name, err)
+			}
+		}
+
+		if err := validateParameters(c.Parameters, r.annoParameters); err != nil {
+			return fmt.Errorf("constraint %s: %w", c.Name, err)
 		}
-		r.annoLabelSelector = &ls
 	}
 
+	r.annoConstraints = cs
 	return nil
 }
 
-func (r *Rego) parseAnnotationsParameters(parameters map[string]any) error {
-	params, err := remarshal[map[string]apiextensionsv1.JSONSchemaProps](parameters)
+// validateParameters checks the given parameter values against the parameter
+// schema of the policy.
+func validateParameters(values map[string]any, parameters map[string]apiextensionsv1.JSONSchemaProps) error {
+	for name := range values {
+		if _, ok := parameters[name]; !ok {
+			return fmt.Errorf("parameter %s is not defined in the parameters annotation", name)
+		}
+	}
+
+	schema := apiextensionsv1.JSONSchemaProps{
+		Type:       "object",
+		Properties: parameters,
+	}
+	var internalSchema apiextensions.JSONSchemaProps
+	if err := apiextensionsv1.Convert_v1_JSONSchemaProps_To_apiextensions_JSONSchemaProps(&schema, &internalSchema, nil); err != nil {
+		return fmt.Errorf("convert parameters schema: %w", err)
+	}
+	validator, _, err := apiservervalidation.NewSchemaValidator(&internalSchema)
 	if err != nil {
-		return fmt.Errorf("unmarshal parameters: %w", err)
+		return fmt.Errorf("create parameters validator: %w", err)
 	}
-	r.annoParameters = params
+
+	if values == nil {
+		values = map[string]any{}
+	}
+	if errs := apiservervalidation.ValidateCustomResource(field.NewPath("parameters"), values, validator); len(errs) > 0 {
+		return fmt.Errorf("invalid parameters: %w", errs.ToAggregate())
+	}
+
 	return nil
 }
 
@@ -357,6 +919,23 @@ func remarshal[Type any, V any](v V) (Type, error) {
 	return result, nil
 }
 
+// remarshalStrict is like remarshal, but returns an error when the value has
+// fields that are not defined by the type.
+func remarshalStrict[Type any, V any](v V) (Type, error) {
+	var result Type
+	b, err := json.Marshal(v)
+	if err != nil {
+		return result, fmt.Errorf("marshal value %v: %w", v, err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&result); err != nil {
+		return result, fmt.Errorf("unmarshal to type %T: %w", result, err)
+	}
+	return result, nil
+}
+
 // Severity returns the severity of the rego file. When a rego file has
 // multiple rules that are considered to be different severities, the first
 // rule is chosen.
@@ -378,8 +957,13 @@ func (r Rego) Severity() Severity {
 }
 
 // Kind returns the Kubernetes Kind of the rego file. The kind of the rego file
-// is determined by the name of the directory that the rego file exists in.
+// is set by the kind annotation, or determined by the name of the directory
+// that the rego file exists in.
 func (r Rego) Kind() string {
+	if r.annoKind != "" {
+		return r.annoKind
+	}
+
 	kind := filepath.Base(filepath.Dir(r.Path()))
 	kind = strings.ReplaceAll(kind, "-", " ")
 	kind = strings.ReplaceAll(kind, "_", " ")
@@ -389,9 +973,24 @@ func (r Rego) Kind() string {
 	return kind
 }
 
-// Name returns the name of the rego file. The name of the rego file is its
-// kind as lowercase.
+// Name returns the name of the rego file. The name of the rego file is set by
+// the name annotation, or is its kind as lowercase. When the Rego describes a
+// Constraint instance, the name of the instance is appended.
 func (r Rego) Name() string {
+	name := strings.ToLower(r.Kind())
+	if r.annoName != "" {
+		name = r.annoName
+	}
+	if r.constraint != nil {
+		name += "-" + r.constraint.Name
+	}
+
+	return name
+}
+
+// TemplateName returns the name of the ConstraintTemplate of the rego file.
+// Gatekeeper requires it to be the kind as lowercase.
+func (r Rego) TemplateName() string {
 	return strings.ToLower(r.Kind())
 }
 
@@ -411,20 +1010,74 @@ func (r Rego) Annotations() map[string]string {
 	return r.metaData.Annotations
 }
 
+// TemplateLabels returns the labels of the ConstraintTemplate found in the
+// header comment of the rego file.
+func (r Rego) TemplateLabels() map[string]string {
+	if r.metaData == nil {
+		return nil
+	}
+	return r.metaData.TemplateLabels
+}
+
+// TemplateAnnotations returns the annotations of the ConstraintTemplate found
+// in the header comment of the rego file.
+func (r Rego) TemplateAnnotations() map[string]string {
+	if r.metaData == nil {
+		return nil
+	}
+	return r.metaData.TemplateAnnotations
+}
+
+// Version returns the version of the policy found in the header comment of
+// the rego file.
+func (r Rego) Version() string {
+	return r.annoVersion
+}
+
 // Title returns the title found in the header comment of the rego file.
 func (r Rego) Title() string {
 	return r.annoTitle
 }
 
 // Enforcement returns the enforcement action in the header comment. Defaults
-// to deny if no enforcement action is specified.
+// to scoped if scoped enforcement actions are specified, and to deny if no
+// enforcement action is specified.
 func (r Rego) Enforcement() string {
 	if r.enforcement != "" {
 		return r.enforcement
 	}
+	if len(r.scopedActions) > 0 {
+		return "scoped"
+	}
 	return "deny"
 }
 
+// ScopedEnforcementActions returns the scoped enforcement actions in the
+// header comment, which apply when the enforcement action is scoped.
+func (r Rego) ScopedEnforcementActions() []ScopedEnforcementAction {
+	return r.scopedActions
+}
+
+// EnforcementActionsAt returns the enforcement actions that apply at the
+// given enforcement point. No actions are returned if the policy is not
+// enforced at the enforcement point.
+func (r Rego) EnforcementActionsAt(point string) []string {
+	if r.Enforcement() != "scoped" {
+		return []string{r.Enforcement()}
+	}
+
+	var actions []string
+	for _, sa := range r.scopedActions {
+		for _, ep := range sa.EnforcementPoints {
+			if (ep.Name == point || ep.Name == EnforcementPointAll) && !contains(actions, sa.Action) {
+				actions = append(actions, sa.Action)
+			}
+		}
+	}
+
+	return actions
+}
+
 // PolicyID returns the identifier of the policy. The returned value will be a
 // blank string if an id was not specified in the policy body.
 func (r Rego) PolicyID() string {
@@ -476,6 +1129,41 @@ func (r Rego) Dependencies() []string {
 	return r.dependencies
 }
 
+// CEL returns the CEL validations of the policy, set in the CEL file next to
+// its Rego, or nil.
+func (r Rego) CEL() *CELSource {
+	return r.cel
+}
+
+// GenerateVAP returns whether Gatekeeper should generate a
+// ValidatingAdmissionPolicy for the policy, or for the Constraint the Rego
+// describes, as set in the generateVAP annotation. It is nil when unset.
+func (r Rego) GenerateVAP() *bool {
+	return r.generateVAP
+}
+
+// AnnotationOperations returns the admission operations that the
+// ConstraintTemplate of the policy is evaluated for, as set in the operations
+// annotation. It is nil when unset.
+func (r Rego) AnnotationOperations() []string {
+	return r.annoOperations
+}
+
+// usesGenerateVAP returns whether the policy, or any of its Constraints, sets
+// generateVAP to true.
+func (r Rego) usesGenerateVAP() bool {
+	if r.generateVAP != nil && *r.generateVAP {
+		return true
+	}
+	for _, c := range r.annoConstraints {
+		if c.GenerateVAP != nil && *c.GenerateVAP {
+			return true
+		}
+	}
+
+	return false
+}
+
 // SkipTemplate returns whether or not the generation of the Template
 // should be skipped. It is only set to true when the @skip-template tag is
 // present in the comment header block
@@ -491,89 +1179,195 @@ func (r Rego) SkipConstraint() bool {
 }
 
 func parseDirectory(directory string, parseImports bool) ([]Rego, error) {
-	// Recursively find all rego files (ignoring test files), starting at the given directory.
-	result, err := loader.NewFileLoader().
-		WithProcessAnnotation(true).
-		Filtered([]string{directory}, func(_ string, info os.FileInfo, _ int) bool {
-			if strings.HasSuffix(info.Name(), "_test.rego") {
-				return true
-			}
-
-			if !info.IsDir() && filepath.Ext(info.Name()) != ".rego" {
-				return true
-			}
-
-			return false
-		})
+	// Problems with a policy are collected, so that all of them can be
+	// reported at once, and the other policies can still be used.
+	result, diagnostics, err := loadValidRegoFiles(directory, false)
 	if err != nil {
-		return nil, fmt.Errorf("filter rego files: %w", err)
+		return nil, err
 	}
 
-	if _, err := result.Compiler(); err != nil {
-		return nil, fmt.Errorf("compile: %w", err)
+	modules := modulesWithoutAnnotations(result)
+
+	// Packages that fail to compile are left out, and the remaining packages
+	// are compiled again until they compile.
+	var compiler *ast.Compiler
+	for {
+		compiler = ast.NewCompiler()
+		if compiler.Compile(modules); !compiler.Failed() {
+			break
+		}
+
+		failedFiles := make(map[string]bool)
+		for _, d := range diagnostic.FromError(diagnostic.CodeRegoCompile, nil, compiler.Errors) {
+			diagnostics.Add(d)
+			failedFiles[d.File] = true
+		}
+
+		failedPackages := make(map[string]bool)
+		for _, module := range modules {
+			if failedFiles[module.Package.Location.File] {
+				failedPackages[module.Package.Path.String()] = true
+			}
+		}
+		if len(failedPackages) == 0 {
+			return nil, diagnostics
+		}
+
+		for name, module := range modules {
+			if failedPackages[module.Package.Path.String()] {
+				delete(modules, name)
+				delete(result.Modules, name)
+			}
+		}
 	}
 
-	files := make(map[string]*loader.RegoFile)
+	files := make(map[string][]*loader.RegoFile)
+	compiled := make(map[*loader.RegoFile]*ast.Module)
 	for m := range result.Modules {
+		compiled[result.Modules[m]] = compiler.Modules[m]
 		// Re-key the loaded rego file map based on the package path of the rego file.
-		// This makes finding the source rego file from an import path much easier.
-		files[result.Modules[m].Parsed.Package.Path.String()] = result.Modules[m]
+		// This makes finding the source rego files from an import path much easier.
+		packagePath := result.Modules[m].Parsed.Package.Path.String()
+		files[packagePath] = append(files[packagePath], result.Modules[m])
+	}
+	for packagePath := range files {
+		sortFiles(files[packagePath])
+	}
+
+	// The files of a package are only merged into one policy when they are in
+	// the same directory. Otherwise, the policies of the other directories
+	// would be lost, so the package is reported at each of them instead.
+	conflicting := make(map[string]bool)
+	for packagePath, packageFiles := range files {
+		var directories []string
+		for _, file := range packageFiles {
+			directories = append(directories, filepath.Dir(file.Name))
+		}
+		directories = dedupe(directories)
+		if len(directories) < 2 {
+			continue
+		}
+
+		sort.Strings(directories)
+		conflicting[packagePath] = true
+		for _, file := range packageFiles {
+			if filepath.Dir(file.Name) != directories[0] {
+				diagnostics.Add(diagnostic.Errorf(diagnostic.CodePackageConflict, file.Parsed.Package.Location, "package %s is declared in multiple directories: %s", packagePath, strings.Join(directories, ", ")))
+			}
+		}
 	}
 
 	var regos []Rego
-	for _, file := range files {
+packages:
+	for packagePath, packageFiles := range files {
+		if conflicting[packagePath] {
+			continue
+		}
+		packageLocation := packageFiles[0].Parsed.Package.Location
+
 		var importPaths []string
 		if parseImports {
-			importPaths, err = getRecursiveImportPaths(file, files)
-			if err != nil {
-				return nil, fmt.Errorf("getRecursiveImportPaths: %w", err)
+			for _, file := range packageFiles {
+				paths, err := getRecursiveImportPaths(file, files)
+				if err != nil {
+					diagnostics.Add(diagnostic.Errorf(diagnostic.CodeInvalidImport, file.Parsed.Package.Location, "%s", err))
+					continue packages
+				}
+				importPaths = append(importPaths, paths...)
 			}
 			importPaths = dedupe(importPaths)
 		}
 
 		var dependencies []string
 		for _, importPath := range importPaths {
-			dependencies = append(dependencies, removeComments(sanitizeRawSource(files[importPath].Raw)))
+			for _, file := range files[importPath] {
+				dependencies = append(dependencies, removeComments(sanitizeRawSource(file.Raw)))
+			}
 		}
 
 		var rules []string
-		for r := range file.Parsed.Rules {
-			rules = append(rules, file.Parsed.Rules[r].Head.Name.String())
+		var parsedRules []*ast.Rule
+		for _, file := range packageFiles {
+			for r := range file.Parsed.Rules {
+				rules = append(rules, file.Parsed.Rules[r].Head.Name.String())
+			}
+			parsedRules = append(parsedRules, file.Parsed.Rules...)
 		}
 
-		var annotations *ast.Annotations
-		for _, a := range file.Parsed.Annotations {
-			if a.Scope == "package" {
-				annotations = a
-				break
-			}
+		annotations, err := mergeAnnotations(packageFiles)
+		if err != nil {
+			diagnostics.Add(diagnostic.Errorf(diagnostic.CodeAnnotationConflict, packageLocation, "merge OPA Metadata annotations of package %s: %s", packagePath, err))
+			continue
 		}
 
-		bodyParams := getRuleParamNames(file.Parsed.Rules)
+		// The parameters are found in the compiled rules, which follow the
+		// imports of the policy to the rules of its libraries.
+		var compiledRules []*ast.Rule
+		for _, file := range packageFiles {
+			compiledRules = append(compiledRules, compiled[file].Rules...)
+		}
+		parameters := getParameterUsage(compiler.Graph, compiledRules)
+		inventory := getInventoryUsage(compiler.Graph, compiledRules)
 
-		if annotations != nil {
-			tempHeaderParams := getHeaderParams(annotations)
-			paramsDiff := paramDiff(bodyParams, tempHeaderParams)
-			if len(paramsDiff) > 0 {
-				return nil, fmt.Errorf("missing definitions for parameters %v found in the policy `%s`", paramsDiff, file.Name)
-			}
+		var raw []byte
+		for _, file := range packageFiles {
+			raw = append(raw, file.Raw...)
+		}
 
+		location := packageLocation
+		if annotations != nil {
+			location = annotations.Location
 		}
+
 		rego := Rego{
-			id:           getPolicyID(file.Parsed.Rules),
-			path:         file.Name,
-			dependencies: dependencies,
-			rules:        rules,
-			raw:          string(file.Raw),
-			sanitizedRaw: sanitizeRawSource(file.Raw),
-			annotations:  annotations,
+			id:            getPolicyID(parsedRules),
+			path:          packageFiles[0].Name,
+			packagePath:   packagePath,
+			location:      location,
+			inputParams:   parameters.names(),
+			dynamicParams: parameters.dynamic != nil,
+			paramDecls:    getParameterDeclarations(packageFiles),
+			syncData:      inventory.requirements(),
+			dynamicSync:   inventory.dynamic,
+			dependencies:  dependencies,
+			rules:         rules,
+			raw:           string(raw),
+			sanitizedRaw:  mergeSources(packageFiles),
+			annotations:   annotations,
 		}
 
 		if annotations != nil {
 			if err := rego.parseAnnotations(annotations); err != nil {
-				return nil, fmt.Errorf("parse OPA Metadata annotations: %w", err)
+				diagnostics.Add(diagnostic.Errorf(diagnostic.CodeInvalidAnnotation, annotations.Location, "parse OPA Metadata annotations: %s", err))
+				continue
+			}
+
+			// Gatekeeper only passes the declared parameters to the policy.
+			var undeclared bool
+			for _, name := range rego.inputParams {
+				if _, ok := rego.annoParameters[name]; !ok {
+					diagnostics.Add(diagnostic.Errorf(diagnostic.CodeUndeclaredParameter, parameters.locations[name], "parameter %q is read from input.parameters but not declared in custom.parameters", name))
+					undeclared = true
+				}
+			}
+			if undeclared {
+				continue
 			}
 		}
+
+		cel, err := readCEL(filepath.Dir(rego.path))
+		if err != nil {
+			location := &ast.Location{File: filepath.Join(filepath.Dir(rego.path), CELFileName), Row: 1, Col: 1}
+			diagnostics.Add(diagnostic.Errorf(diagnostic.CodeInvalidCEL, location, "%s", err))
+			continue
+		}
+		rego.cel = cel
+
+		if rego.cel == nil && rego.usesGenerateVAP() {
+			diagnostics.Add(diagnostic.Errorf(diagnostic.CodeInvalidAnnotation, location, "generateVAP requires CEL validations in %s", CELFileName))
+			continue
+		}
+
 		regos = append(regos, rego)
 	}
 
@@ -582,44 +1376,101 @@ func parseDirectory(directory string, parseImports bool) ([]Rego, error) {
 	sort.Slice(regos, func(i, j int) bool {
 		return regos[i].path < regos[j].path
 	})
+	diagnostics.Sort()
 
-	return regos, nil
+	return regos, diagnostics.Err()
 }
 
-func sanitizeRawSource(raw []byte) string {
-	// Many YAML parsers have problems handling carriage returns and tabs so we sanitize the Rego
-	// before storing it so it can be rendered properly.
-	raw = bytes.ReplaceAll(raw, []byte("\r"), []byte(""))
-	raw = bytes.ReplaceAll(raw, []byte("\t"), []byte("  "))
-	return trimEachLine(string(raw))
+// LoadModules returns the modules of all rego files in the directory,
+// including the test files, keyed by their file names. The files are loaded
+// the same way as the policies, and the modules can be compiled together.
+// Files that cannot be parsed are left out, and their diagnostics are
+// returned as the error along with the modules of the other files.
+func LoadModules(directory string) (map[string]*ast.Module, error) {
+	result, diagnostics, err := loadValidRegoFiles(directory, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// The modules are keyed by their file names, which are also the files of
+	// their locations.
+	modules := make(map[string]*ast.Module, len(result.Modules))
+	for name, module := range modulesWithoutAnnotations(result) {
+		modules[result.Modules[name].Name] = module
+	}
+
+	diagnostics.Sort()
+	return modules, diagnostics.Err()
 }
 
-func getRuleParamNames(rules []*ast.Rule) []string {
-	re := regexp.MustCompile(`input\.parameters\.([a-zA-Z0-9_-]+)`)
-	var ruleParams []string
-	for _, r := range rules {
-		matches := re.FindAllStringSubmatch(r.String(), -1)
-		for _, match := range matches {
-			if !contains(ruleParams, match[1]) {
-				ruleParams = append(ruleParams, match[1])
+// loadValidRegoFiles loads the rego files in the directory. Files that cannot
+// be parsed are skipped, so that they do not hide the problems of the other
+// files, and are returned as diagnostics.
+func loadValidRegoFiles(directory string, tests bool) (*loader.Result, diagnostic.List, error) {
+	var diagnostics diagnostic.List
+	skipped := make(map[string]bool)
+	for {
+		result, err := loadRegoFiles(directory, tests, skipped)
+		if err == nil {
+			return result, diagnostics, nil
+		}
+
+		var loaderErrors loader.Errors
+		if !errors.As(err, &loaderErrors) {
+			return nil, nil, fmt.Errorf("filter rego files: %w", err)
+		}
+
+		for _, e := range loaderErrors {
+			for _, d := range diagnostic.FromError(diagnostic.CodeRegoParse, nil, e) {
+				if d.File == "" || skipped[d.File] {
+					return nil, nil, append(diagnostics, d)
+				}
+				skipped[d.File] = true
+				diagnostics.Add(d)
 			}
 		}
 	}
-
-	return ruleParams
 }
 
-func getHeaderParams(annotations *ast.Annotations) []Parameter {
-	params, ok := annotations.Custom[annoParameters].(map[string]any)
-	if !ok {
-		return nil
-	}
-	var parameters []Parameter
-	for p := range params {
-		parameters = append(parameters, Parameter{Name: p})
+// modulesWithoutAnnotations returns the modules of the loaded files without
+// their annotations. The package annotations of a policy can be split across
+// its files, which the compiler rejects. They are merged when creating the
+// policy instead, so they are left out when compiling.
+func modulesWithoutAnnotations(result *loader.Result) map[string]*ast.Module {
+	modules := make(map[string]*ast.Module, len(result.Modules))
+	for name, file := range result.Modules {
+		module := file.Parsed.Copy()
+		module.Annotations = nil
+		modules[name] = module
 	}
 
-	return parameters
+	return modules
+}
+
+// loadRegoFiles recursively loads all rego files in the directory, except for
+// the skipped files, and the test files unless tests is set.
+func loadRegoFiles(directory string, tests bool, skipped map[string]bool) (*loader.Result, error) {
+	return loader.NewFileLoader().
+		WithProcessAnnotation(true).
+		Filtered([]string{directory}, func(path string, info os.FileInfo, _ int) bool {
+			if !tests && strings.HasSuffix(info.Name(), "_test.rego") {
+				return true
+			}
+
+			if !info.IsDir() && filepath.Ext(info.Name()) != ".rego" {
+				return true
+			}
+
+			return skipped[path]
+		})
+}
+
+func sanitizeRawSource(raw []byte) string {
+	// Many YAML parsers have problems handling carriage returns and tabs so we sanitize the Rego
+	// before storing it so it can be rendered properly.
+	raw = bytes.ReplaceAll(raw, []byte("\r"), []byte(""))
+	raw = bytes.ReplaceAll(raw, []byte("\t"), []byte("  "))
+	return trimEachLine(string(raw))
 }
 
 func trimEachLine(raw string) string {
@@ -661,11 +1512,11 @@ func getPolicyID(rules []*ast.Rule) string {
 	return policyID
 }
 
-func getRecursiveImportPaths(regoFile *loader.RegoFile, regoFiles map[string]*loader.RegoFile) ([]string, error) {
+func getRecursiveImportPaths(regoFile *loader.RegoFile, regoFiles map[string][]*loader.RegoFile) ([]string, error) {
 	var recursiveImports []string
 	for i := range regoFile.Parsed.Imports {
 		importPath := regoFile.Parsed.Imports[i].Path.String()
-		if !strings.HasPrefix(importPath, "data.lib.") {
+		if !isLibrary(importPath) {
 			continue
 		}
 
@@ -682,17 +1533,167 @@ func getRecursiveImportPaths(regoFile *loader.RegoFile, regoFiles map[string]*lo
 			}
 		}
 
-		recursiveImports = append(recursiveImports, imported.Parsed.Package.Path.String())
-		remainingImports, err := getRecursiveImportPaths(imported, regoFiles)
-		if err != nil {
-			return nil, fmt.Errorf("get recursive import paths: %w", err)
+		recursiveImports = append(recursiveImports, imported[0].Parsed.Package.Path.String())
+		for _, file := range imported {
+			remainingImports, err := getRecursiveImportPaths(file, regoFiles)
+			if err != nil {
+				return nil, fmt.Errorf("get recursive import paths: %w", err)
+			}
+			recursiveImports = append(recursiveImports, remainingImports...)
 		}
-		recursiveImports = append(recursiveImports, remainingImports...)
 	}
 
 	return recursiveImports, nil
 }
 
+// isLibrary returns whether the package path is the path of a library. Only
+// libraries are included as dependencies of the policies that import them.
+func isLibrary(packagePath string) bool {
+	return strings.HasPrefix(packagePath, "data.lib.")
+}
+
+// sortFiles sorts the files of a package so that the src.rego file, if any,
+// comes first, followed by the other files ordered by their paths. The path of
+// the first file is used as the path of the policy.
+func sortFiles(files []*loader.RegoFile) {
+	sort.Slice(files, func(i, j int) bool {
+		iSrc := filepath.Base(files[i].Name) == "src.rego"
+		jSrc := filepath.Base(files[j].Name) == "src.rego"
+		if iSrc != jSrc {
+			return iSrc
+		}
+		return files[i].Name < files[j].Name
+	})
+}
+
+// mergeSources returns the sanitized source of the files of a package as a
+// single module. The imports of the other files are added after the imports
+// of the first file, and their rules are appended in order.
+func mergeSources(files []*loader.RegoFile) string {
+	merged := sanitizeRawSource(files[0].Raw)
+	if len(files) == 1 {
+		return merged
+	}
+
+	lines := strings.Split(strings.TrimRight(merged, "\n"), "\n")
+	imports := make(map[string]bool)
+	for _, imp := range files[0].Parsed.Imports {
+		imports[strings.TrimSpace(lines[imp.Location.Row-1])] = true
+	}
+
+	var additionalImports []string
+	var bodies []string
+	for _, file := range files[1:] {
+		fileLines := strings.Split(sanitizeRawSource(file.Raw), "\n")
+		for _, imp := range file.Parsed.Imports {
+			text := strings.TrimSpace(fileLines[imp.Location.Row-1])
+			if imports[text] {
+				continue
+			}
+			imports[text] = true
+			additionalImports = append(additionalImports, text)
+		}
+
+		bodies = append(bodies, strings.Trim(strings.Join(fileLines[headerEnd(file.Parsed):], "\n"), "\n"))
+	}
+
+	end := headerEnd(files[0].Parsed)
+
+	var result []string
+	result = append(result, lines[:end]...)
+	result = append(result, additionalImports...)
+	result = append(result, lines[end:]...)
+	for _, body := range bodies {
+		if body != "" {
+			result = append(result, "", body)
+		}
+	}
+
+	return strings.Join(result, "\n") + "\n"
+}
+
+// headerEnd returns the number of lines taken up by the package and import
+// statements of the module, including any comments before them.
+func headerEnd(module *ast.Module) int {
+	end := module.Package.Location.Row
+	for _, imp := range module.Imports {
+		if imp.Location.Row > end {
+			end = imp.Location.Row
+		}
+	}
+
+	return end
+}
+
+// mergeAnnotations merges the package annotations of the files of a package.
+// An error listing every conflict is returned when files set a different
+// value for the same annotation.
+func mergeAnnotations(files []*loader.RegoFile) (*ast.Annotations, error) {
+	var merged *ast.Annotations
+	sources := make(map[string]string)
+	var conflicts []string
+	merge := func(key string, current any, value any, file string) bool {
+		if reflect.DeepEqual(current, value) {
+			return false
+		}
+		if source, ok := sources[key]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("%s: %s, %s", key, source, file))
+			return false
+		}
+		sources[key] = file
+		return true
+	}
+
+	for _, file := range files {
+		annotations := getPackageAnnotations(file.Parsed)
+		if annotations == nil {
+			continue
+		}
+
+		if merged == nil {
+			copied := *annotations
+			copied.Title = ""
+			copied.Description = ""
+			copied.Custom = make(map[string]any, len(annotations.Custom))
+			merged = &copied
+		}
+
+		if annotations.Title != "" && merge("title", merged.Title, annotations.Title, file.Name) {
+			merged.Title = annotations.Title
+		}
+		if annotations.Description != "" && merge("description", merged.Description, annotations.Description, file.Name) {
+			merged.Description = annotations.Description
+		}
+
+		keys := make([]string, 0, len(annotations.Custom))
+		for key := range annotations.Custom {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if merge("custom."+key, merged.Custom[key], annotations.Custom[key], file.Name) {
+				merged.Custom[key] = annotations.Custom[key]
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return nil, fmt.Errorf("found %d conflicting annotation(s):\n  %s", len(conflicts), strings.Join(conflicts, "\n  "))
+	}
+
+	return merged, nil
+}
+
+func getPackageAnnotations(module *ast.Module) *ast.Annotations {
+	for _, a := range module.Annotations {
+		if a.Scope == "package" {
+			return a
+		}
+	}
+
+	return nil
+}
+
 func dedupe(collection []string) []string {
 	var dedupedCollection []string
 	for _, item := range collection {
@@ -715,19 +1716,3 @@ func contains(collection []string, item string) bool {
 
 	return false
 }
-
-func paramDiff(bodyParams []string, headerParams []Parameter) []string {
-	var hps []string
-	for _, hp := range headerParams {
-		hps = append(hps, hp.Name)
-	}
-
-	var res []string
-	for _, bp := range bodyParams {
-		if !contains(hps, bp) {
-			res = append(res, bp)
-		}
-	}
-
-	return res
-}
@@ -1,10 +1,15 @@
 package rego
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
 	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/loader"
+	"github.com/plexsystems/konstraint/internal/diagnostic"
 )
 
 func TestKind(t *testing.T) {
@@ -172,51 +177,984 @@ func TestGetPolicyID_Null(t *testing.T) {
 	}
 }
 
-func TestGetRuleParamNamesFromInput(t *testing.T) {
+func TestGetParameterUsage(t *testing.T) {
+	const library = `package lib.params
+
+labels := input.parameters.labels
+
+exempt(name) {
+	input.parameters.exemptions[_] == name
+}
+
+unused := input.parameters.unused
+`
+
 	testCases := []struct {
-		desc string
-		rule string
-		want []string
+		desc    string
+		policy  string
+		want    []string
+		dynamic bool
 	}{
 		{
-			desc: "No Parameters",
-			rule: `foo = "bar" { true }`,
+			desc:   "No Parameters",
+			policy: `foo = "bar" { true }`,
 		},
 		{
 			desc: "Parameters in rule body",
-			rule: `violation[msg] {
+			policy: `violation[msg] {
 				foo := "bar"
 				bar := input.parameters.baz
 				baz := input.parameters.foobars[_]
 				box := input.parameters.baz
+				msg := "x"
 			}`,
 			want: []string{"baz", "foobars"},
 		},
 		{
-			desc: "Parameters in rule value",
-			rule: `foo = input.parameters.bar { true }`,
-			want: []string{"bar"},
+			desc:   "Parameters in rule value",
+			policy: `foo = input.parameters.bar { true }`,
+			want:   []string{"bar"},
 		},
 		{
-			desc: "Parameters in body and value",
-			rule: `foo = input.parameters.bar {
-				x := input.parameters.baz
+			desc:   "Parameters in brackets",
+			policy: `foo = input.parameters["bar-baz"] { true }`,
+			want:   []string{"bar-baz"},
+		},
+		{
+			desc: "Aliased parameters",
+			policy: `violation[msg] {
+				params := input.parameters
+				p := params
+				i := input
+				p.foo
+				i.parameters.bar
+				msg := "x"
 			}`,
-			want: []string{"bar", "baz"},
+			want: []string{"bar", "foo"},
+		},
+		{
+			desc:   "Parameters read with object.get",
+			policy: `foo := object.get(input.parameters, "bar", [])`,
+			want:   []string{"bar"},
+		},
+		{
+			desc: "Parameters read in a library",
+			policy: `import data.lib.params
+
+violation[msg] {
+				params.labels[_]
+				params.exempt("foo")
+				msg := "x"
+			}`,
+			want: []string{"exemptions", "labels"},
+		},
+		{
+			desc: "Dynamic parameters",
+			policy: `violation[msg] {
+				input.parameters[name]
+				msg := name
+			}`,
+			dynamic: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			policy, err := ast.ParseModule("policy.rego", "package policy\n\n"+tc.policy)
+			if err != nil {
+				t.Fatalf("parse policy: %s", err)
+			}
+			lib, err := ast.ParseModule("lib.rego", library)
+			if err != nil {
+				t.Fatalf("parse library: %s", err)
+			}
+
+			compiler := ast.NewCompiler()
+			if compiler.Compile(map[string]*ast.Module{"policy.rego": policy, "lib.rego": lib}); compiler.Failed() {
+				t.Fatalf("compile: %s", compiler.Errors)
+			}
+
+			usage := getParameterUsage(compiler.Graph, compiler.Modules["policy.rego"].Rules)
+			if actual := usage.names(); !reflect.DeepEqual(tc.want, actual) && (len(tc.want) > 0 || len(actual) > 0) {
+				t.Errorf("unexpected parameters. expected %+v, actual %+v", tc.want, actual)
+			}
+			if dynamic := usage.dynamic != nil; dynamic != tc.dynamic {
+				t.Errorf("unexpected dynamic read. expected %v, actual %v", tc.dynamic, dynamic)
+			}
+			for name, location := range usage.locations {
+				if location == nil {
+					t.Errorf("expected a location for parameter %s", name)
+				}
+			}
+		})
+	}
+}
+
+func TestGetInventoryUsage(t *testing.T) {
+	const library = `package lib.inventory
+
+namespaces := data.inventory.cluster.v1.Namespace
+
+ingresses(namespace) := data.inventory.namespace[namespace]["networking.k8s.io/v1"].Ingress
+`
+
+	testCases := []struct {
+		desc    string
+		policy  string
+		want    [][]SyncData
+		dynamic bool
+	}{
+		{
+			desc:   "No inventory",
+			policy: `foo = "bar" { true }`,
+		},
+		{
+			desc: "Cluster and namespaced resources",
+			policy: `violation[msg] {
+				data.inventory.cluster["rbac.authorization.k8s.io/v1"].ClusterRole[_]
+				data.inventory.namespace[_].v1.Service[_]
+				msg := "x"
+			}`,
+			want: [][]SyncData{
+				{{Groups: []string{""}, Versions: []string{"v1"}, Kinds: []string{"Service"}}},
+				{{Groups: []string{"rbac.authorization.k8s.io"}, Versions: []string{"v1"}, Kinds: []string{"ClusterRole"}}},
+			},
+		},
+		{
+			desc: "Inventory read in a library",
+			policy: `import data.lib.inventory
+
+violation[msg] {
+				inventory.namespaces[_]
+				inventory.ingresses("default")[_]
+				msg := "x"
+			}`,
+			want: [][]SyncData{
+				{{Groups: []string{""}, Versions: []string{"v1"}, Kinds: []string{"Namespace"}}},
+				{{Groups: []string{"networking.k8s.io"}, Versions: []string{"v1"}, Kinds: []string{"Ingress"}}},
+			},
+		},
+		{
+			desc: "Dynamic kind",
+			policy: `violation[msg] {
+				data.inventory.namespace[_][_][kind][_]
+				msg := kind
+			}`,
+			dynamic: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			policy, err := ast.ParseModule("policy.rego", "package policy\n\n"+tc.policy)
+			if err != nil {
+				t.Fatalf("parse policy: %s", err)
+			}
+			lib, err := ast.ParseModule("lib.rego", library)
+			if err != nil {
+				t.Fatalf("parse library: %s", err)
+			}
+
+			compiler := ast.NewCompiler()
+			if compiler.Compile(map[string]*ast.Module{"policy.rego": policy, "lib.rego": lib}); compiler.Failed() {
+				t.Fatalf("compile: %s", compiler.Errors)
+			}
+
+			usage := getInventoryUsage(compiler.Graph, compiler.Modules["policy.rego"].Rules)
+			if actual := usage.requirements(); !reflect.DeepEqual(tc.want, actual) {
+				t.Errorf("unexpected requirements. expected %+v, actual %+v", tc.want, actual)
+			}
+			if dynamic := usage.dynamic != nil; dynamic != tc.dynamic {
+				t.Errorf("unexpected dynamic read. expected %v, actual %v", tc.dynamic, dynamic)
+			}
+		})
+	}
+}
+
+func TestParseAnnotationsConstraints(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		custom  string
+		wantErr bool
+	}{
+		{
+			desc: "Valid constraints",
+			custom: `
+#   parameters:
+#     labels:
+#       type: array
+#       items:
+#         type: string
+#   constraints:
+#   - name: prod
+#     parameters:
+#       labels:
+#       - owner
+#   - name: dev
+#     enforcement: warn`,
+		},
+		{
+			desc: "Parameter with wrong type",
+			custom: `
+#   parameters:
+#     labels:
+#       type: array
+#       items:
+#         type: string
+#   constraints:
+#   - name: prod
+#     parameters:
+#       labels: owner`,
+			wantErr: true,
+		},
+		{
+			desc: "Undefined parameter",
+			custom: `
+#   constraints:
+#   - name: prod
+#     parameters:
+#       labels:
+#       - owner`,
+			wantErr: true,
+		},
+		{
+			desc: "Invalid name",
+			custom: `
+#   constraints:
+#   - name: Prod_Cluster`,
+			wantErr: true,
+		},
+		{
+			desc: "Duplicate name",
+			custom: `
+#   constraints:
+#   - name: prod
+#   - name: prod`,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			module := "# METADATA\n# custom:" + tc.custom + "\npackage foo\n"
+			parsed, err := ast.ParseModuleWithOpts("", module, ast.ParserOptions{ProcessAnnotation: true})
+			if err != nil {
+				t.Fatalf("parse module: %s", err)
+			}
+
+			rego := Rego{}
+			err = rego.parseAnnotations(parsed.Annotations[0])
+			if tc.wantErr && err == nil {
+				t.Errorf("expected error, got none")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestParseAnnotationsMatchers(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		custom  string
+		wantErr bool
+	}{
+		{
+			desc: "All match fields",
+			custom: `
+#   matchers:
+#     kinds:
+#     - apiGroups: [""]
+#       kinds: ["Pod"]
+#     scope: Namespaced
+#     namespaces: ["prod-*"]
+#     excludedNamespaces: ["kube-system", "*-system"]
+#     labelSelector:
+#       matchExpressions:
+#       - key: app
+#         operator: In
+#         values: ["web"]
+#     namespaceSelector:
+#       matchLabels:
+#         team: payments
+#     name: "frontend-*"
+#     source: Original`,
+		},
+		{
+			desc: "Unknown field",
+			custom: `
+#   matchers:
+#     excludedNamespace: ["kube-system"]`,
+			wantErr: true,
+		},
+		{
+			desc: "Invalid scope",
+			custom: `
+#   matchers:
+#     scope: Namespace`,
+			wantErr: true,
+		},
+		{
+			desc: "Invalid source",
+			custom: `
+#   matchers:
+#     source: Mutated`,
+			wantErr: true,
+		},
+		{
+			desc: "Invalid namespace wildcard",
+			custom: `
+#   matchers:
+#     namespaces: ["prod-*-eu"]`,
+			wantErr: true,
+		},
+		{
+			desc: "Invalid label selector operator",
+			custom: `
+#   matchers:
+#     labelSelector:
+#       matchExpressions:
+#       - key: app
+#         operator: Equals
+#         values: ["web"]`,
+			wantErr: true,
+		},
+		{
+			desc: "Missing label selector values",
+			custom: `
+#   matchers:
+#     namespaceSelector:
+#       matchExpressions:
+#       - key: app
+#         operator: In`,
+			wantErr: true,
+		},
+		{
+			desc: "Matchers is not an object",
+			custom: `
+#   matchers: []`,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			module := "# METADATA\n# custom:" + tc.custom + "\npackage foo\n"
+			parsed, err := ast.ParseModuleWithOpts("", module, ast.ParserOptions{ProcessAnnotation: true})
+			if err != nil {
+				t.Fatalf("parse module: %s", err)
+			}
+
+			rego := Rego{}
+			err = rego.parseAnnotations(parsed.Annotations[0])
+			if tc.wantErr && err == nil {
+				t.Errorf("expected error, got none")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestForConstraint(t *testing.T) {
+	module := `# METADATA
+# custom:
+#   enforcement: dryrun
+#   matchers:
+#     namespaces:
+#     - default
+#   constraints:
+#   - name: prod
+#     enforcement: warn
+#     matchers:
+#       namespaces:
+#       - prod
+package foo
+`
+	parsed, err := ast.ParseModuleWithOpts("", module, ast.ParserOptions{ProcessAnnotation: true})
+	if err != nil {
+		t.Fatalf("parse module: %s", err)
+	}
+
+	policy := Rego{
+		path:        "some/path/my-policy/src.rego",
+		annotations: parsed.Annotations[0],
+	}
+	if err := policy.parseAnnotations(parsed.Annotations[0]); err != nil {
+		t.Fatalf("parse annotations: %s", err)
+	}
+
+	instance, err := policy.ForConstraint(policy.AnnotationConstraints()[0])
+	if err != nil {
+		t.Fatalf("for constraint: %s", err)
+	}
+
+	if actual := instance.Name(); actual != "mypolicy-prod" {
+		t.Errorf("unexpected Name. expected %v, actual %v", "mypolicy-prod", actual)
+	}
+	if actual := instance.Enforcement(); actual != "warn" {
+		t.Errorf("unexpected Enforcement. expected %v, actual %v", "warn", actual)
+	}
+	if actual := instance.AnnotationNamespaceMatchers(); !reflect.DeepEqual(actual, []string{"prod"}) {
+		t.Errorf("unexpected namespace matchers. expected %v, actual %v", []string{"prod"}, actual)
+	}
+	if actual := policy.AnnotationNamespaceMatchers(); !reflect.DeepEqual(actual, []string{"default"}) {
+		t.Errorf("policy namespace matchers were modified: %v", actual)
+	}
+}
+
+func TestKindAndNameAnnotations(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		custom           string
+		wantKind         string
+		wantName         string
+		wantTemplateName string
+		wantErr          bool
+	}{
+		{
+			desc:             "No overrides",
+			custom:           "\n#   enforcement: deny",
+			wantKind:         "MyPolicy",
+			wantName:         "mypolicy",
+			wantTemplateName: "mypolicy",
+		},
+		{
+			desc:             "Kind override",
+			custom:           "\n#   kind: K8sRequiredLabels",
+			wantKind:         "K8sRequiredLabels",
+			wantName:         "k8srequiredlabels",
+			wantTemplateName: "k8srequiredlabels",
+		},
+		{
+			desc:             "Name override",
+			custom:           "\n#   name: required-labels",
+			wantKind:         "MyPolicy",
+			wantName:         "required-labels",
+			wantTemplateName: "mypolicy",
+		},
+		{
+			desc:    "Invalid kind",
+			custom:  "\n#   kind: my-policy",
+			wantErr: true,
+		},
+		{
+			desc:    "Invalid name",
+			custom:  "\n#   name: My_Policy",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			module := "# METADATA\n# custom:" + tc.custom + "\npackage foo\n"
+			parsed, err := ast.ParseModuleWithOpts("", module, ast.ParserOptions{ProcessAnnotation: true})
+			if err != nil {
+				t.Fatalf("parse module: %s", err)
+			}
+
+			policy := Rego{
+				path: "some/path/my-policy/src.rego",
+			}
+			err = policy.parseAnnotations(parsed.Annotations[0])
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse annotations: %s", err)
+			}
+
+			if actual := policy.Kind(); actual != tc.wantKind {
+				t.Errorf("unexpected Kind. expected %v, actual %v", tc.wantKind, actual)
+			}
+			if actual := policy.Name(); actual != tc.wantName {
+				t.Errorf("unexpected Name. expected %v, actual %v", tc.wantName, actual)
+			}
+			if actual := policy.TemplateName(); actual != tc.wantTemplateName {
+				t.Errorf("unexpected TemplateName. expected %v, actual %v", tc.wantTemplateName, actual)
+			}
+		})
+	}
+}
+
+func TestMergeSources(t *testing.T) {
+	files := []*loader.RegoFile{
+		mustParseRegoFile(t, "policy/src.rego", `# METADATA
+# title: The title
+package foo
+
+import future.keywords.if
+
+violation if {
+  helper
+}
+`),
+		mustParseRegoFile(t, "policy/helpers.rego", `package foo
+
+import future.keywords.if
+import data.lib.bar
+
+# The helper
+helper if {
+  bar.baz
+}
+`),
+	}
+
+	const expected = `package foo
+
+import future.keywords.if
+import data.lib.bar
+
+violation if {
+  helper
+}
+
+helper if {
+  bar.baz
+}`
+
+	actual := Rego{sanitizedRaw: mergeSources(files)}.Source()
+	if actual != expected {
+		t.Errorf("unexpected Source. expected %v, actual %v", expected, actual)
+	}
+}
+
+func TestMergeAnnotations(t *testing.T) {
+	files := []*loader.RegoFile{
+		mustParseRegoFile(t, "policy/src.rego", `# METADATA
+# title: The title
+# custom:
+#   enforcement: warn
+package foo
+`),
+		mustParseRegoFile(t, "policy/helpers.rego", `# METADATA
+# description: The description
+# custom:
+#   enforcement: warn
+#   skipConstraint: true
+package foo
+`),
+	}
+
+	annotations, err := mergeAnnotations(files)
+	if err != nil {
+		t.Fatalf("merge annotations: %s", err)
+	}
+
+	if annotations.Title != "The title" || annotations.Description != "The description" {
+		t.Errorf("unexpected title and description: %q, %q", annotations.Title, annotations.Description)
+	}
+	expectedCustom := map[string]any{"enforcement": "warn", "skipConstraint": true}
+	if !reflect.DeepEqual(annotations.Custom, expectedCustom) {
+		t.Errorf("unexpected custom annotations. expected %v, actual %v", expectedCustom, annotations.Custom)
+	}
+
+	files = append(files, mustParseRegoFile(t, "policy/other.rego", `# METADATA
+# title: Another title
+# custom:
+#   enforcement: deny
+package foo
+`))
+
+	const expectedErr = `found 2 conflicting annotation(s):
+  title: policy/src.rego, policy/other.rego
+  custom.enforcement: policy/src.rego, policy/other.rego`
+	if _, err := mergeAnnotations(files); err == nil || err.Error() != expectedErr {
+		t.Errorf("unexpected error. expected %v, actual %v", expectedErr, err)
+	}
+}
+
+func mustParseRegoFile(t *testing.T, name string, source string) *loader.RegoFile {
+	t.Helper()
+
+	module, err := ast.ParseModuleWithOpts(name, source, ast.ParserOptions{ProcessAnnotation: true})
+	if err != nil {
+		t.Fatalf("parse module: %s", err)
+	}
+
+	return &loader.RegoFile{Name: name, Raw: []byte(source), Parsed: module}
+}
+
+func TestScopedEnforcementActions(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		custom      string
+		wantActions map[string][]string
+		wantErr     bool
+	}{
+		{
+			desc: "Scoped enforcement actions",
+			custom: `
+#   scopedEnforcementActions:
+#   - action: warn
+#     enforcementPoints:
+#     - name: validation.gatekeeper.sh
+#   - action: deny
+#     enforcementPoints:
+#     - name: "*"`,
+			wantActions: map[string][]string{
+				EnforcementPointValidation: {"warn", "deny"},
+				EnforcementPointAudit:      {"deny"},
+			},
+		},
+		{
+			desc: "Not scoped",
+			custom: `
+#   enforcement: dryrun`,
+			wantActions: map[string][]string{
+				EnforcementPointValidation: {"dryrun"},
+				EnforcementPointAudit:      {"dryrun"},
+			},
+		},
+		{
+			desc: "Not enforced at enforcement point",
+			custom: `
+#   enforcement: scoped
+#   scopedEnforcementActions:
+#   - action: deny
+#     enforcementPoints:
+#     - name: audit.gatekeeper.sh`,
+			wantActions: map[string][]string{
+				EnforcementPointValidation: nil,
+				EnforcementPointAudit:      {"deny"},
+			},
+		},
+		{
+			desc: "Scoped without scopedEnforcementActions",
+			custom: `
+#   enforcement: scoped`,
+			wantErr: true,
+		},
+		{
+			desc: "Not scoped with scopedEnforcementActions",
+			custom: `
+#   enforcement: warn
+#   scopedEnforcementActions:
+#   - action: deny
+#     enforcementPoints:
+#     - name: audit.gatekeeper.sh`,
+			wantErr: true,
+		},
+		{
+			desc: "Invalid action",
+			custom: `
+#   scopedEnforcementActions:
+#   - action: block
+#     enforcementPoints:
+#     - name: audit.gatekeeper.sh`,
+			wantErr: true,
+		},
+		{
+			desc: "Invalid enforcement point",
+			custom: `
+#   scopedEnforcementActions:
+#   - action: deny
+#     enforcementPoints:
+#     - name: webhook`,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			module := "# METADATA\n# custom:" + tc.custom + "\npackage foo\n"
+			parsed, err := ast.ParseModuleWithOpts("", module, ast.ParserOptions{ProcessAnnotation: true})
+			if err != nil {
+				t.Fatalf("parse module: %s", err)
+			}
+
+			policy := Rego{}
+			err = policy.parseAnnotations(parsed.Annotations[0])
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse annotations: %s", err)
+			}
+
+			for point, expected := range tc.wantActions {
+				if actual := policy.EnforcementActionsAt(point); !reflect.DeepEqual(expected, actual) {
+					t.Errorf("unexpected actions at %s. expected %v, actual %v", point, expected, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestParameterLocation(t *testing.T) {
+	files := map[string]string{
+		"src.rego": `# METADATA
+# title: Parameters
+package parameters
+
+violation[msg] {
+	input.parameters.labels[_]
+	msg := "parameters"
+}
+`,
+		"helpers.rego": `# Helpers of the policy.
+
+# METADATA
+# custom:
+#   parameters: {labels: {type: array, items: {type: string}}, "unused": {type: string}}
+package parameters
+`,
+	}
+
+	dir := filepath.Join(t.TempDir(), "parameters")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	violations, err := GetViolations(dir)
+	if err != nil {
+		t.Fatalf("get violations: %s", err)
+	}
+
+	// The parameters are declared in flow style in the METADATA block of the
+	// helpers.
+	src := filepath.Join(dir, "src.rego")
+	helpers := filepath.Join(dir, "helpers.rego")
+	for name, expected := range map[string]ast.Location{
+		"labels":    {File: helpers, Row: 3, Col: 1},
+		"unused":    {File: helpers, Row: 3, Col: 1},
+		"undefined": {File: src, Row: 1, Col: 1},
+	} {
+		actual := violations[0].ParameterLocation(name)
+		if actual.File != expected.File || actual.Row != expected.Row || actual.Col != expected.Col {
+			t.Errorf("unexpected location of %s. expected %s:%d:%d, actual %s:%d:%d", name, expected.File, expected.Row, expected.Col, actual.File, actual.Row, actual.Col)
+		}
+	}
+}
+
+func TestOperations(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		custom  string
+		want    []string
+		wantErr bool
+	}{
+		{
+			desc: "Operations",
+			custom: `
+#   operations: [DELETE]`,
+			want: []string{"DELETE"},
+		},
+		{
+			desc: "All operations",
+			custom: `
+#   operations: ["*"]`,
+			want: []string{"*"},
+		},
+		{
+			desc: "Unset",
+			custom: `
+#   enforcement: deny`,
+		},
+		{
+			desc: "Empty",
+			custom: `
+#   operations: []`,
+			wantErr: true,
+		},
+		{
+			desc: "Not a list",
+			custom: `
+#   operations: CREATE`,
+			wantErr: true,
+		},
+		{
+			desc: "Invalid operation",
+			custom: `
+#   operations: [CREATE, PATCH]`,
+			wantErr: true,
+		},
+		{
+			desc: "Lowercase operation",
+			custom: `
+#   operations: [create]`,
+			wantErr: true,
+		},
+		{
+			desc: "Duplicate operation",
+			custom: `
+#   operations: [CREATE, CREATE]`,
+			wantErr: true,
+		},
+		{
+			desc: "All operations with other operations",
+			custom: `
+#   operations: ["*", DELETE]`,
+			wantErr: true,
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.desc, func(t *testing.T) {
-			rule, err := ast.ParseRule(tc.rule)
+			module := "# METADATA\n# custom:" + tc.custom + "\npackage foo\n"
+			parsed, err := ast.ParseModuleWithOpts("", module, ast.ParserOptions{ProcessAnnotation: true})
+			if err != nil {
+				t.Fatalf("parse module: %s", err)
+			}
+
+			policy := Rego{}
+			err = policy.parseAnnotations(parsed.Annotations[0])
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got none")
+				}
+				return
+			}
 			if err != nil {
-				t.Fatalf("parse rule: %s", err)
+				t.Fatalf("parse annotations: %s", err)
 			}
 
-			actual := getRuleParamNames([]*ast.Rule{rule})
-			if !(reflect.DeepEqual(tc.want, actual)) {
-				t.Errorf("unexpected bodyParams. expected %+v, actual %+v", tc.want, actual)
+			if actual := policy.AnnotationOperations(); !reflect.DeepEqual(tc.want, actual) {
+				t.Errorf("unexpected operations. expected %v, actual %v", tc.want, actual)
 			}
 		})
 	}
 }
+
+func TestParseDirectoryDiagnostics(t *testing.T) {
+	policies := map[string]string{
+		"valid/src.rego": `# METADATA
+# title: Valid
+package valid
+
+violation[msg] {
+	msg := "valid"
+}
+`,
+		"undeclared/src.rego": `# METADATA
+# title: Undeclared
+package undeclared
+
+violation[msg] {
+	input.parameters.labels
+	msg := "undeclared"
+}
+`,
+		"invalid/src.rego": `# METADATA
+# title: Invalid
+# custom:
+#   matchers:
+#     kinds: invalid
+package invalid
+
+violation[msg] {
+	msg := "invalid"
+}
+`,
+		"unparsable/src.rego": `# METADATA
+# title: Unparsable
+package unparsable
+
+violation[msg] {
+`,
+		"uncompilable/src.rego": `# METADATA
+# title: Uncompilable
+package uncompilable
+
+violation[msg] {
+	msg := undefined_function("uncompilable")
+}
+`,
+		"uncompilable/helpers.rego": `package uncompilable
+
+helper := true
+`,
+		"generatevap/src.rego": `# METADATA
+# title: Generate VAP without CEL
+# custom:
+#   generateVAP: true
+package generatevap
+
+violation[msg] {
+	msg := "generatevap"
+}
+`,
+		"invalidcel/src.rego": `# METADATA
+# title: Invalid CEL
+package invalidcel
+
+violation[msg] {
+	msg := "invalidcel"
+}
+`,
+		"invalidcel/src.cel.yaml": `validations: []`,
+		"a-pol/src.rego": `# METADATA
+# title: Shared A
+package shared
+
+violation[msg] {
+	msg := "a"
+}
+`,
+		"b-pol/src.rego": `# METADATA
+# title: Shared B
+package shared
+
+violation[msg] {
+	msg := "b"
+}
+`,
+	}
+
+	dir := t.TempDir()
+	for name, content := range policies {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	regos, err := parseDirectory(dir, false)
+
+	var diagnostics diagnostic.List
+	if !errors.As(err, &diagnostics) {
+		t.Fatalf("expected diagnostics, got %v", err)
+	}
+
+	expected := []struct {
+		code string
+		file string
+	}{
+		{diagnostic.CodePackageConflict, filepath.Join(dir, "b-pol", "src.rego")},
+		{diagnostic.CodeInvalidAnnotation, filepath.Join(dir, "generatevap", "src.rego")},
+		{diagnostic.CodeInvalidAnnotation, filepath.Join(dir, "invalid", "src.rego")},
+		{diagnostic.CodeInvalidCEL, filepath.Join(dir, "invalidcel", "src.cel.yaml")},
+		{diagnostic.CodeRegoCompile, filepath.Join(dir, "uncompilable", "src.rego")},
+		{diagnostic.CodeUndeclaredParameter, filepath.Join(dir, "undeclared", "src.rego")},
+		{diagnostic.CodeRegoParse, filepath.Join(dir, "unparsable", "src.rego")},
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("unexpected diagnostics. expected %d, actual %v", len(expected), diagnostics)
+	}
+	for i, e := range expected {
+		if diagnostics[i].Code != e.code || diagnostics[i].File != e.file || diagnostics[i].Line == 0 {
+			t.Errorf("unexpected diagnostic. expected %s in %s, actual %v", e.code, e.file, diagnostics[i])
+		}
+	}
+
+	if len(regos) != 1 || regos[0].Title() != "Valid" {
+		t.Errorf("expected only the valid policy to be returned, actual %v", regos)
+	}
+}
@@ -0,0 +1,108 @@
+package rego
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// CustomSchema is the JSON Schema of the custom section of the metadata
+// annotations of a policy.
+//
+//go:embed custom.schema.json
+var CustomSchema []byte
+
+var customSchema = mustCompileSchema(CustomSchema)
+
+var schemaPrinter = message.NewPrinter(language.English)
+
+func mustCompileSchema(schema []byte) *jsonschema.Schema {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schema))
+	if err != nil {
+		panic(fmt.Sprintf("unmarshal schema: %s", err))
+	}
+
+	const url = "custom.schema.json"
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(url, doc); err != nil {
+		panic(fmt.Sprintf("add schema: %s", err))
+	}
+
+	return compiler.MustCompile(url)
+}
+
+// validateCustom validates the custom section of the metadata annotations
+// against CustomSchema. The error lists every invalid value by its key path,
+// such as custom.matchers.kinds[0].
+func validateCustom(custom map[string]any) error {
+	if custom == nil {
+		return nil
+	}
+
+	// The values are decoded from YAML, so they are converted into the JSON
+	// values that the validator expects first.
+	b, err := json.Marshal(custom)
+	if err != nil {
+		return fmt.Errorf("marshal custom: %w", err)
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("unmarshal custom: %w", err)
+	}
+
+	err = customSchema.Validate(doc)
+	if err == nil {
+		return nil
+	}
+
+	validationError, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return fmt.Errorf("validate custom: %w", err)
+	}
+
+	var problems []string
+	for _, e := range leafErrors(validationError) {
+		problems = append(problems, fmt.Sprintf("%s: %s", keyPath(e.InstanceLocation), e.ErrorKind.LocalizedString(schemaPrinter)))
+	}
+	sort.Strings(problems)
+
+	return fmt.Errorf("invalid custom metadata: %s", strings.Join(problems, "; "))
+}
+
+// leafErrors returns the errors in the tree that have no causes, which are
+// the ones that describe the actual problems.
+func leafErrors(err *jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if len(err.Causes) == 0 {
+		return []*jsonschema.ValidationError{err}
+	}
+
+	var leaves []*jsonschema.ValidationError
+	for _, cause := range err.Causes {
+		leaves = append(leaves, leafErrors(cause)...)
+	}
+
+	return leaves
+}
+
+// keyPath formats the location of a value in the custom section, such as
+// custom.constraints[1].name.
+func keyPath(location []string) string {
+	path := "custom"
+	for _, token := range location {
+		if _, err := strconv.Atoi(token); err == nil {
+			path += "[" + token + "]"
+			continue
+		}
+		path += "." + token
+	}
+
+	return path
+}
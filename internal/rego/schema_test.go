@@ -0,0 +1,115 @@
+package rego
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+func TestValidateCustom(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		custom   string
+		expected string
+	}{
+		{
+			desc: "Valid",
+			custom: `
+matchers:
+  kinds:
+  - apiGroups: [""]
+    kinds: ["Pod"]
+parameters:
+  labels:
+    type: array
+    items:
+      type: string
+constraints:
+- name: dev
+  parameters:
+    labels: ["owner"]
+unknown: value`,
+		},
+		{
+			desc:     "Matchers of the wrong type",
+			custom:   `matchers: []`,
+			expected: "custom.matchers: got array, want object",
+		},
+		{
+			desc:     "Parameters of the wrong type",
+			custom:   `parameters: foo`,
+			expected: "custom.parameters: got string, want object",
+		},
+		{
+			desc: "Nested problems",
+			custom: `
+constraints:
+- enforcement: deny
+- name: prod
+  matchers:
+    kinds: Pod
+skipTemplate: "true"`,
+			expected: "custom.constraints[0]: missing property 'name'; custom.constraints[1].matchers.kinds: got string, want array; custom.skipTemplate: got string, want boolean",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			annotations := parseCustom(t, tc.custom)
+
+			err := validateCustom(annotations.Custom)
+			if tc.expected == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("expected error, got none")
+			}
+			if !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("unexpected error. expected %q, actual %q", tc.expected, err.Error())
+			}
+
+			// The conversion must not panic on the invalid values.
+			var rego Rego
+			if err := rego.parseAnnotations(annotations); err == nil {
+				t.Error("expected parse error, got none")
+			}
+		})
+	}
+}
+
+func parseCustom(t *testing.T, custom string) *ast.Annotations {
+	t.Helper()
+
+	var comment strings.Builder
+	comment.WriteString("# METADATA\n# custom:\n")
+	for _, line := range strings.Split(strings.TrimPrefix(custom, "\n"), "\n") {
+		comment.WriteString("#   " + line + "\n")
+	}
+
+	module, err := ast.ParseModuleWithOpts("src.rego", comment.String()+"package test\n", ast.ParserOptions{ProcessAnnotation: true})
+	if err != nil {
+		t.Fatalf("parse module: %s", err)
+	}
+
+	return module.Annotations[0]
+}
+
+// The schema in the docs is generated from the embedded schema, which is the
+// only one to edit.
+func TestDocsCustomSchema(t *testing.T) {
+	docs, err := os.ReadFile("../../docs/custom.schema.json")
+	if err != nil {
+		t.Fatalf("read docs schema: %s", err)
+	}
+
+	if !bytes.Equal(docs, CustomSchema) {
+		t.Errorf("docs/custom.schema.json is out of date, run make update-static to generate it from internal/rego/custom.schema.json")
+	}
+}
@@ -2,6 +2,12 @@
 # title: The title
 # description: The description
 # custom:
+#   version: 1.0.1
+#   operations: [CREATE, UPDATE]
+#   templateAnnotations:
+#     argocd.argoproj.io/sync-wave: "-1"
+#   templateLabels:
+#     owner: platform
 #   parameters:
 #     super:
 #       type: string
@@ -0,0 +1,45 @@
+# METADATA
+# title: The title
+# description: The description
+# custom:
+#   parameters:
+#     labels:
+#       type: array
+#       items:
+#         type: string
+#   matchers:
+#     kinds:
+#     - apiGroups:
+#       - ""
+#       kinds:
+#       - Namespace
+#   constraints:
+#   - name: prod
+#     parameters:
+#       labels:
+#       - owner
+#       - cost-center
+#     matchers:
+#       kinds:
+#       - apiGroups:
+#         - ""
+#         kinds:
+#         - Namespace
+#       labelSelector:
+#         matchLabels:
+#           env: prod
+#   - name: dev
+#     enforcement: warn
+#     parameters:
+#       labels:
+#       - owner
+package test_multipleconstraints
+
+import future.keywords.if
+import data.lib.libraryA
+
+policyID := "P123456"
+
+violation if {
+    input.parameters.labels[_] == "owner"
+}
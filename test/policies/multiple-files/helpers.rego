@@ -0,0 +1,12 @@
+# METADATA
+# custom:
+#   enforcement: warn
+package test_multiplefiles
+
+import future.keywords.if
+import data.lib.libraryA
+
+# Helpers are kept in a separate file.
+has_owner if {
+    input.review.object.metadata.labels.owner
+}
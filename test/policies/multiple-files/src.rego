@@ -0,0 +1,16 @@
+# METADATA
+# title: The title
+# description: The description
+# custom:
+#   matchers:
+#     namespaces:
+#     - dev
+package test_multiplefiles
+
+import future.keywords.if
+
+policyID := "P123456"
+
+violation if {
+    has_owner # some comment
+}
@@ -0,0 +1,21 @@
+# METADATA
+# title: The title
+# description: The description
+# custom:
+#   scopedEnforcementActions:
+#   - action: warn
+#     enforcementPoints:
+#     - name: validation.gatekeeper.sh
+#   - action: deny
+#     enforcementPoints:
+#     - name: audit.gatekeeper.sh
+#     - name: gator.gatekeeper.sh
+package test_scopedenforcement
+
+import future.keywords.if
+
+policyID := "P123456"
+
+violation if {
+    true # some comment
+}